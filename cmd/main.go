@@ -3,21 +3,19 @@ package main
 import (
 	"bytes"
 	"context"
-	"crypto/rand"
-	"crypto/rsa"
 	"crypto/tls"
-	"crypto/x509"
-	"crypto/x509/pkix"
-	"encoding/pem"
 	"fmt"
 	"io"
 	"log"
-	"math/big"
 	"net/http"
+	"os"
 	"reflect"
 	"time"
 
+	"github.com/zerok-ai/zerok-injector/pkg/cert"
+	"github.com/zerok-ai/zerok-injector/pkg/config"
 	"github.com/zerok-ai/zerok-injector/pkg/inject"
+	"github.com/zerok-ai/zerok-injector/pkg/zkclient"
 	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -30,6 +28,8 @@ var (
 	webhookPath        = "/zk-injector"
 	webhookNamespace   = "zk-injector"
 	webhookServiceName = "zk-injector"
+	// Secret holding the CA and serving certificate of the webhook.
+	webhookCertSecretName = "zk-injector-certs"
 )
 
 func injectRequestHandler(w http.ResponseWriter, r *http.Request) {
@@ -60,6 +60,18 @@ func errorResponse(err error, w http.ResponseWriter) {
 }
 
 func main() {
+	err := config.Load()
+	if err != nil {
+		fmt.Printf("Failed to load the injector config, using the defaults: %v.\n", err)
+	}
+
+	go func() {
+		err := zkclient.StartOwnerInformers(make(chan struct{}))
+		if err != nil {
+			fmt.Printf("Failed to start the owner informers, workloads will be guessed from names: %v.\n", err)
+		}
+	}()
+
 	dnsNames := []string{
 		webhookServiceName,
 		webhookServiceName + "." + webhookNamespace,
@@ -68,19 +80,14 @@ func main() {
 	commonName := webhookServiceName + "." + webhookNamespace + ".svc"
 
 	org := "zerok"
-	caPEM, serverCertPEM, serverCertKeyPEM, err := generateCert([]string{org}, dnsNames, commonName)
-	if err != nil {
-		fmt.Printf("Failed to generate certificate: %v.\n", err)
-	}
-
-	serverPair, err := tls.X509KeyPair(serverCertPEM.Bytes(), serverCertKeyPEM.Bytes())
-	if err != nil {
-		fmt.Printf("Failed to load server certificate key pair: %v.\n", err)
-	}
-
-	err = createOrUpdateMutatingWebhookConfiguration(caPEM, webhookServiceName, webhookNamespace)
-	if err != nil {
-		fmt.Printf("Failed to create or update the mutating webhook configuration: %v\n", err)
+	// All replicas share the certificates stored in the secret, which the manager renews and rotates while
+	// serving, publishing the CA bundle to the webhook configuration whenever it changes.
+	certManager := cert.NewManager(zkclient.GetK8sClient(), webhookNamespace, webhookCertSecretName, []string{org}, dnsNames, commonName, func(caBundle []byte) error {
+		return createOrUpdateMutatingWebhookConfiguration(bytes.NewBuffer(caBundle), webhookServiceName, webhookNamespace)
+	})
+	if err := certManager.Start(make(chan struct{})); err != nil {
+		fmt.Printf("Failed to load or create the webhook certificates: %v.\n", err)
+		os.Exit(1)
 	}
 
 	mux := http.NewServeMux()
@@ -90,7 +97,7 @@ func main() {
 	s := &http.Server{
 		Addr:           ":8443",
 		Handler:        mux,
-		TLSConfig:      &tls.Config{Certificates: []tls.Certificate{serverPair}},
+		TLSConfig:      &tls.Config{GetCertificate: certManager.GetCertificate},
 		ReadTimeout:    30 * time.Second,
 		WriteTimeout:   30 * time.Second,
 		MaxHeaderBytes: 1 << 20,
@@ -142,46 +149,95 @@ func createOrUpdateMutatingWebhookConfiguration(caPEM *bytes.Buffer, webhookServ
 }
 
 func createMutatingWebhook(sideEffect admissionregistrationv1.SideEffectClass, caPEM *bytes.Buffer, webhookService string, webhookNamespace string, fail admissionregistrationv1.FailurePolicyType) *admissionregistrationv1.MutatingWebhookConfiguration {
-	timeOut := int32(30)
+	// Pods in namespaces labelled for injection, unless the pod opts out with the zerok.ai/inject label.
+	namespaceWebhook := createWebhook("zk-webhook.zerok.ai", sideEffect, caPEM, webhookService, webhookNamespace, fail)
+	namespaceWebhook.NamespaceSelector = &metav1.LabelSelector{
+		MatchLabels: map[string]string{
+			"zk-injection": "enabled",
+		},
+		MatchExpressions: []metav1.LabelSelectorRequirement{getDeniedNamespacesRequirement()},
+	}
+	namespaceWebhook.ObjectSelector = &metav1.LabelSelector{
+		MatchExpressions: []metav1.LabelSelectorRequirement{
+			{
+				Key:      "zerok.ai/inject",
+				Operator: metav1.LabelSelectorOpNotIn,
+				Values:   []string{"false"},
+			},
+		},
+	}
+
+	// Pods which opt in with the zerok.ai/inject label from namespaces that are not labelled for injection.
+	podWebhook := createWebhook("zk-webhook-pod.zerok.ai", sideEffect, caPEM, webhookService, webhookNamespace, fail)
+	podWebhook.NamespaceSelector = &metav1.LabelSelector{
+		MatchExpressions: []metav1.LabelSelectorRequirement{
+			{
+				Key:      "zk-injection",
+				Operator: metav1.LabelSelectorOpNotIn,
+				Values:   []string{"enabled"},
+			},
+			getDeniedNamespacesRequirement(),
+		},
+	}
+	podWebhook.ObjectSelector = &metav1.LabelSelector{
+		MatchLabels: map[string]string{
+			"zerok.ai/inject": "true",
+		},
+	}
+
 	mutatingWebhookConfig := &admissionregistrationv1.MutatingWebhookConfiguration{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: webhookName,
 		},
-		Webhooks: []admissionregistrationv1.MutatingWebhook{{
-			Name:                    "zk-webhook.zerok.ai",
-			AdmissionReviewVersions: []string{"v1"},
-			SideEffects:             &sideEffect,
-			TimeoutSeconds:          &timeOut,
-			ClientConfig: admissionregistrationv1.WebhookClientConfig{
-				CABundle: caPEM.Bytes(),
-				Service: &admissionregistrationv1.ServiceReference{
-					Name:      webhookService,
-					Namespace: webhookNamespace,
-					Path:      &webhookPath,
-				},
+		Webhooks: []admissionregistrationv1.MutatingWebhook{namespaceWebhook, podWebhook},
+	}
+	return mutatingWebhookConfig
+}
+
+// The system namespaces and the namespace of the injector are kept away from the webhook altogether, the
+// injector still checks them on its side in case the selector is edited.
+func getDeniedNamespacesRequirement() metav1.LabelSelectorRequirement {
+	return metav1.LabelSelectorRequirement{
+		Key:      "kubernetes.io/metadata.name",
+		Operator: metav1.LabelSelectorOpNotIn,
+		Values:   inject.GetDeniedNamespaces(),
+	}
+}
+
+func createWebhook(name string, sideEffect admissionregistrationv1.SideEffectClass, caPEM *bytes.Buffer, webhookService string, webhookNamespace string, fail admissionregistrationv1.FailurePolicyType) admissionregistrationv1.MutatingWebhook {
+	timeOut := int32(30)
+	// Called again when a later webhook changes the pod, so containers added by meshes and other injectors are
+	// instrumented as well.
+	reinvocationPolicy := admissionregistrationv1.IfNeededReinvocationPolicy
+	return admissionregistrationv1.MutatingWebhook{
+		Name:                    name,
+		ReinvocationPolicy:      &reinvocationPolicy,
+		AdmissionReviewVersions: []string{"v1"},
+		SideEffects:             &sideEffect,
+		TimeoutSeconds:          &timeOut,
+		ClientConfig: admissionregistrationv1.WebhookClientConfig{
+			CABundle: caPEM.Bytes(),
+			Service: &admissionregistrationv1.ServiceReference{
+				Name:      webhookService,
+				Namespace: webhookNamespace,
+				Path:      &webhookPath,
 			},
-			Rules: []admissionregistrationv1.RuleWithOperations{
-				{
-					Operations: []admissionregistrationv1.OperationType{
-						admissionregistrationv1.Create,
-						admissionregistrationv1.Update,
-					},
-					Rule: admissionregistrationv1.Rule{
-						APIGroups:   []string{""},
-						APIVersions: []string{"v1"},
-						Resources:   []string{"pods"},
-					},
+		},
+		Rules: []admissionregistrationv1.RuleWithOperations{
+			{
+				Operations: []admissionregistrationv1.OperationType{
+					admissionregistrationv1.Create,
+					admissionregistrationv1.Update,
 				},
-			},
-			NamespaceSelector: &metav1.LabelSelector{
-				MatchLabels: map[string]string{
-					"zk-injection": "enabled",
+				Rule: admissionregistrationv1.Rule{
+					APIGroups:   []string{""},
+					APIVersions: []string{"v1"},
+					Resources:   []string{"pods"},
 				},
 			},
-			FailurePolicy: &fail,
-		}},
+		},
+		FailurePolicy: &fail,
 	}
-	return mutatingWebhookConfig
 }
 
 func areWebHooksSame(foundWebhookConfig *admissionregistrationv1.MutatingWebhookConfiguration, mutatingWebhookConfig *admissionregistrationv1.MutatingWebhookConfiguration) bool {
@@ -194,8 +250,10 @@ func areWebHooksSame(foundWebhookConfig *admissionregistrationv1.MutatingWebhook
 			reflect.DeepEqual(foundWebhookConfig.Webhooks[i].AdmissionReviewVersions, mutatingWebhookConfig.Webhooks[i].AdmissionReviewVersions) &&
 			reflect.DeepEqual(foundWebhookConfig.Webhooks[i].SideEffects, mutatingWebhookConfig.Webhooks[i].SideEffects) &&
 			reflect.DeepEqual(foundWebhookConfig.Webhooks[i].FailurePolicy, mutatingWebhookConfig.Webhooks[i].FailurePolicy) &&
+			reflect.DeepEqual(foundWebhookConfig.Webhooks[i].ReinvocationPolicy, mutatingWebhookConfig.Webhooks[i].ReinvocationPolicy) &&
 			reflect.DeepEqual(foundWebhookConfig.Webhooks[i].Rules, mutatingWebhookConfig.Webhooks[i].Rules) &&
 			reflect.DeepEqual(foundWebhookConfig.Webhooks[i].NamespaceSelector, mutatingWebhookConfig.Webhooks[i].NamespaceSelector) &&
+			reflect.DeepEqual(foundWebhookConfig.Webhooks[i].ObjectSelector, mutatingWebhookConfig.Webhooks[i].ObjectSelector) &&
 			reflect.DeepEqual(foundWebhookConfig.Webhooks[i].ClientConfig.CABundle, mutatingWebhookConfig.Webhooks[i].ClientConfig.CABundle) &&
 			reflect.DeepEqual(foundWebhookConfig.Webhooks[i].ClientConfig.Service, mutatingWebhookConfig.Webhooks[i].ClientConfig.Service)
 		if !equal {
@@ -204,79 +262,3 @@ func areWebHooksSame(foundWebhookConfig *admissionregistrationv1.MutatingWebhook
 	}
 	return true
 }
-
-func generateCert(orgs, dnsNames []string, commonName string) (*bytes.Buffer, *bytes.Buffer, *bytes.Buffer, error) {
-	ca := &x509.Certificate{
-		SerialNumber:          big.NewInt(int64(time.Now().Day())),
-		Subject:               pkix.Name{Organization: orgs},
-		NotBefore:             time.Now(),
-		NotAfter:              time.Now().AddDate(10, 0, 0),
-		IsCA:                  true,
-		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
-		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
-		BasicConstraintsValid: true,
-	}
-
-	caPrivateKey, err := rsa.GenerateKey(rand.Reader, 4096)
-	if err != nil {
-		return nil, nil, nil, err
-	}
-
-	caBytes, err := x509.CreateCertificate(rand.Reader, ca, ca, &caPrivateKey.PublicKey, caPrivateKey)
-	if err != nil {
-		return nil, nil, nil, err
-	}
-
-	caPEM := new(bytes.Buffer)
-	_ = pem.Encode(caPEM, &pem.Block{
-		Type:  "CERTIFICATE",
-		Bytes: caBytes,
-	})
-
-	serverCertPEM, serverPrivateKeyPEM, err := getServerCertPEM(orgs, dnsNames, commonName, ca, caPrivateKey)
-
-	if err != nil {
-		return nil, nil, nil, err
-	}
-
-	return caPEM, serverCertPEM, serverPrivateKeyPEM, nil
-}
-
-func getServerCertPEM(orgs, dnsNames []string, commonName string, parentCa *x509.Certificate, parentPrivateKey *rsa.PrivateKey) (*bytes.Buffer, *bytes.Buffer, error) {
-	serverCert := &x509.Certificate{
-		DNSNames:     dnsNames,
-		SerialNumber: big.NewInt(1024),
-		Subject: pkix.Name{
-			CommonName:   commonName,
-			Organization: orgs,
-		},
-		NotBefore:   time.Now(),
-		NotAfter:    time.Now().AddDate(10, 0, 0),
-		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
-		KeyUsage:    x509.KeyUsageDigitalSignature,
-	}
-
-	serverPrivateKey, err := rsa.GenerateKey(rand.Reader, 4096)
-	if err != nil {
-		return nil, nil, err
-	}
-
-	serverCertBytes, err := x509.CreateCertificate(rand.Reader, serverCert, parentCa, &serverPrivateKey.PublicKey, parentPrivateKey)
-	if err != nil {
-		return nil, nil, err
-	}
-
-	serverCertPEM := new(bytes.Buffer)
-	_ = pem.Encode(serverCertPEM, &pem.Block{
-		Type:  "CERTIFICATE",
-		Bytes: serverCertBytes,
-	})
-
-	serverPrivateKeyPEM := new(bytes.Buffer)
-	_ = pem.Encode(serverPrivateKeyPEM, &pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(serverPrivateKey),
-	})
-
-	return serverCertPEM, serverPrivateKeyPEM, nil
-}
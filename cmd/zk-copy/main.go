@@ -0,0 +1,174 @@
+// zk-copy runs in the init container injected into pods. It copies the agent files the instrumented
+// containers need into the shared volume, checks them against the sha256 sums of the bundle manifest and
+// leaves a summary in the termination log, which kubectl describe shows for the init container.
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/zerok-ai/zerok-injector/pkg/catalog"
+)
+
+const terminationLogPath = "/dev/termination-log"
+
+type copier struct {
+	source    string
+	target    string
+	checksums map[string]string
+	// Path of the manifest relative to the source, when it is kept with the agent files. It is not copied.
+	manifest string
+	uid      int
+	gid      int
+	copied   int
+}
+
+func main() {
+	manifestPath := flag.String("manifest", "/opt/zerok/manifest.json", "manifest of the agent bundles")
+	source := flag.String("source", "/opt/zerok", "directory holding the agent files")
+	target := flag.String("target", "/opt/temp", "directory of the shared volume")
+	files := flag.String("files", "", "comma separated files or directories to copy, relative to the source, everything when empty")
+	uid := flag.Int("uid", -1, "user owning the copied files, only applied when running as root")
+	gid := flag.Int("gid", -1, "group owning the copied files, only applied when running as root")
+	flag.Parse()
+
+	c := &copier{source: *source, target: *target, checksums: map[string]string{}, uid: *uid, gid: *gid}
+	summary, err := c.run(*manifestPath, *files)
+	if err != nil {
+		summary = "zerok agent copy failed: " + err.Error()
+	}
+	fmt.Println(summary)
+	// The termination log is missing outside of kubernetes, which is not an error of the copy.
+	_ = os.WriteFile(terminationLogPath, []byte(summary), 0644)
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+func (c *copier) run(manifestPath string, files string) (string, error) {
+	// Every file is checked, so a missing manifest fails the copy. Init images without one use the legacy cp.
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return "", fmt.Errorf("error caught while reading the manifest %v: %v", manifestPath, err)
+	}
+	manifest, err := catalog.Parse(data)
+	if err != nil {
+		return "", fmt.Errorf("error caught while parsing the manifest %v: %v", manifestPath, err)
+	}
+	for _, bundle := range manifest.Bundles {
+		for _, file := range bundle.Files {
+			c.checksums[filepath.Clean(file.Path)] = strings.ToLower(file.SHA256)
+		}
+	}
+	if relativePath, err := filepath.Rel(c.source, manifestPath); err == nil && !strings.HasPrefix(relativePath, "..") {
+		c.manifest = relativePath
+	}
+
+	paths := []string{"."}
+	if files != "" {
+		paths = []string{}
+		for _, path := range strings.Split(files, ",") {
+			path = filepath.Clean(strings.TrimSpace(path))
+			if path == "." || filepath.IsAbs(path) || strings.HasPrefix(path, "..") {
+				return "", fmt.Errorf("invalid file %q", path)
+			}
+			paths = append(paths, path)
+		}
+	}
+
+	for _, path := range paths {
+		if err := c.copyPath(path); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("zerok agent copied: %v files to %v, all verified against the manifest", c.copied, c.target), nil
+}
+
+func (c *copier) copyPath(path string) error {
+	return filepath.Walk(filepath.Join(c.source, path), func(sourcePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return fmt.Errorf("error caught while reading %v: %v", sourcePath, err)
+		}
+		relativePath, err := filepath.Rel(c.source, sourcePath)
+		if err != nil {
+			return err
+		}
+		targetPath := filepath.Join(c.target, relativePath)
+
+		if info.IsDir() {
+			if err := os.MkdirAll(targetPath, 0755); err != nil {
+				return fmt.Errorf("error caught while creating %v: %v", targetPath, err)
+			}
+			return c.setOwner(targetPath)
+		}
+		if !info.Mode().IsRegular() || relativePath == c.manifest {
+			return nil
+		}
+		if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
+			return fmt.Errorf("error caught while creating %v: %v", filepath.Dir(targetPath), err)
+		}
+		return c.copyFile(sourcePath, targetPath, relativePath, info.Mode())
+	})
+}
+
+// copyFile copies one file and checks the sha256 of what was written against the manifest, which has to list
+// the file. Files are readable by every user, and executable by every user when they were executable in the
+// image.
+func (c *copier) copyFile(sourcePath string, targetPath string, relativePath string, mode os.FileMode) error {
+	permissions := os.FileMode(0644)
+	if mode&0111 != 0 {
+		permissions = 0755
+	}
+
+	source, err := os.Open(sourcePath)
+	if err != nil {
+		return fmt.Errorf("error caught while opening %v: %v", sourcePath, err)
+	}
+	defer source.Close()
+
+	target, err := os.OpenFile(targetPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, permissions)
+	if err != nil {
+		return fmt.Errorf("error caught while creating %v: %v", targetPath, err)
+	}
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(target, hash), source)
+	closeErr := target.Close()
+	if err != nil {
+		return fmt.Errorf("error caught while copying %v: %v", relativePath, err)
+	}
+	if closeErr != nil {
+		return fmt.Errorf("error caught while writing %v: %v", relativePath, closeErr)
+	}
+
+	expected, ok := c.checksums[relativePath]
+	if !ok {
+		_ = os.Remove(targetPath)
+		return fmt.Errorf("no checksum for %v in the manifest", relativePath)
+	}
+	if actual := hex.EncodeToString(hash.Sum(nil)); actual != expected {
+		_ = os.Remove(targetPath)
+		return fmt.Errorf("checksum mismatch for %v: expected %v, got %v", relativePath, expected, actual)
+	}
+	// The umask may have taken bits away when creating the file.
+	if err := os.Chmod(targetPath, permissions); err != nil {
+		return fmt.Errorf("error caught while setting the permissions of %v: %v", relativePath, err)
+	}
+	c.copied++
+	return c.setOwner(targetPath)
+}
+
+func (c *copier) setOwner(path string) error {
+	if os.Geteuid() != 0 || (c.uid < 0 && c.gid < 0) {
+		return nil
+	}
+	if err := os.Chown(path, c.uid, c.gid); err != nil {
+		return fmt.Errorf("error caught while changing the owner of %v: %v", path, err)
+	}
+	return nil
+}
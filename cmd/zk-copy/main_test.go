@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeSource(t *testing.T, files map[string]string, listed map[string]string) string {
+	t.Helper()
+	source := t.TempDir()
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Join(source, filepath.Dir(path)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(source, path), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if listed != nil {
+		entries := []string{}
+		for path, content := range listed {
+			sum := sha256.Sum256([]byte(content))
+			entries = append(entries, fmt.Sprintf(`{"path":%q,"sha256":%q}`, path, hex.EncodeToString(sum[:])))
+		}
+		manifest := `{"bundles":[{"name":"zerok-java","version":"1.0.0","language":"java","files":[` + strings.Join(entries, ",") + `]}]}`
+		if err := os.WriteFile(filepath.Join(source, "manifest.json"), []byte(manifest), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return source
+}
+
+func runCopy(t *testing.T, source string, files string) (string, string, error) {
+	t.Helper()
+	target := t.TempDir()
+	c := &copier{source: source, target: target, checksums: map[string]string{}, uid: -1, gid: -1}
+	summary, err := c.run(filepath.Join(source, "manifest.json"), files)
+	return target, summary, err
+}
+
+func TestCopyVerifiesEveryFile(t *testing.T) {
+	files := map[string]string{"zerok-agent.sh": "#!/bin/sh", "lib/agent.jar": "jar"}
+	source := writeSource(t, files, files)
+
+	target, summary, err := runCopy(t, source, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(summary, "2 files") {
+		t.Fatalf("unexpected summary %q", summary)
+	}
+	if _, err := os.Stat(filepath.Join(target, "lib/agent.jar")); err != nil {
+		t.Fatalf("expected the jar to be copied: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(target, "manifest.json")); !os.IsNotExist(err) {
+		t.Fatalf("expected the manifest not to be copied: %v", err)
+	}
+}
+
+func TestCopyFailsWithoutManifest(t *testing.T) {
+	source := writeSource(t, map[string]string{"zerok-agent.sh": "#!/bin/sh"}, nil)
+	if _, _, err := runCopy(t, source, "zerok-agent.sh"); err == nil {
+		t.Fatal("expected the copy to fail without a manifest")
+	}
+}
+
+func TestCopyFailsForUnlistedFiles(t *testing.T) {
+	source := writeSource(t, map[string]string{"zerok-agent.sh": "#!/bin/sh", "extra.jar": "jar"}, map[string]string{"zerok-agent.sh": "#!/bin/sh"})
+	if _, _, err := runCopy(t, source, "zerok-agent.sh,extra.jar"); err == nil || !strings.Contains(err.Error(), "no checksum") {
+		t.Fatalf("expected the copy to fail for the unlisted file, got %v", err)
+	}
+}
+
+func TestCopyFailsOnChecksumMismatch(t *testing.T) {
+	source := writeSource(t, map[string]string{"zerok-agent.sh": "#!/bin/sh"}, map[string]string{"zerok-agent.sh": "#!/bin/bash"})
+	target, _, err := runCopy(t, source, "zerok-agent.sh")
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("expected a checksum mismatch, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(target, "zerok-agent.sh")); !os.IsNotExist(err) {
+		t.Fatalf("expected the mismatching file to be removed: %v", err)
+	}
+}
@@ -4,9 +4,11 @@ go 1.18
 
 require (
 	github.com/docker/docker v20.10.22+incompatible
+	github.com/evanphx/json-patch v4.12.0+incompatible
 	k8s.io/api v0.26.0
 	k8s.io/apimachinery v0.26.0
 	k8s.io/client-go v0.26.0
+	sigs.k8s.io/yaml v1.3.0
 )
 
 require (
@@ -56,5 +58,4 @@ require (
 	k8s.io/utils v0.0.0-20221107191617-1a15be271d1d // indirect
 	sigs.k8s.io/json v0.0.0-20220713155537-f223a00ba0e2 // indirect
 	sigs.k8s.io/structured-merge-diff/v4 v4.2.3 // indirect
-	sigs.k8s.io/yaml v1.3.0 // indirect
 )
@@ -1,2 +1,21 @@
-docker build . -t rajeevzerok/init-container
-docker push rajeevzerok/init-container
\ No newline at end of file
+#!/bin/bash
+# Builds and pushes the init and agent bundle images. The OpenTelemetry java agent is downloaded into
+# resources/ unless it is already there, and checked against OTEL_JAVA_AGENT_SHA256 either way.
+set -euo pipefail
+
+cd "$(dirname "$0")"
+VERSION=${VERSION:-latest}
+OTEL_JAVA_AGENT_VERSION=${OTEL_JAVA_AGENT_VERSION:-1.32.0}
+OTEL_JAVA_AGENT_SHA256=${OTEL_JAVA_AGENT_SHA256:?set to the sha256 of opentelemetry-javaagent.jar $OTEL_JAVA_AGENT_VERSION}
+
+if [ ! -f resources/opentelemetry-javaagent.jar ]; then
+  curl -fsSL -o resources/opentelemetry-javaagent.jar \
+    "https://github.com/open-telemetry/opentelemetry-java-instrumentation/releases/download/v$OTEL_JAVA_AGENT_VERSION/opentelemetry-javaagent.jar"
+fi
+echo "$OTEL_JAVA_AGENT_SHA256  resources/opentelemetry-javaagent.jar" | sha256sum -c -
+
+./manifest.sh "$VERSION" > resources/manifest.json
+docker build -f Dockerfile .. --build-arg OTEL_JAVA_AGENT_SHA256="$OTEL_JAVA_AGENT_SHA256" -t rajeevzerok/init-container:$VERSION --label ai.zerok.agent.manifest="$(cat resources/manifest.json)"
+docker build -f Dockerfile .. --build-arg OTEL_JAVA_AGENT_SHA256="$OTEL_JAVA_AGENT_SHA256" --target bundle -t rajeevzerok/agent-bundle:$VERSION --label ai.zerok.agent.manifest="$(cat resources/manifest.json)"
+docker push rajeevzerok/init-container:$VERSION
+docker push rajeevzerok/agent-bundle:$VERSION
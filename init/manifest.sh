@@ -0,0 +1,21 @@
+#!/bin/sh
+# Writes the manifest of the agent bundles in resources/ as JSON, for the ai.zerok.agent.manifest label of the
+# init image and for the ConfigMap read by the injector.
+set -e
+
+VERSION=${1:?usage: manifest.sh <version>}
+cd "$(dirname "$0")/resources"
+
+files=""
+for file in zerok-agent.sh opentelemetry-javaagent.jar zk-otel-extension.jar; do
+  if [ ! -f "$file" ]; then
+    echo "missing $file in resources/" >&2
+    exit 1
+  fi
+  sha=$(sha256sum "$file" | cut -d ' ' -f 1)
+  files="$files${files:+,}{\"path\":\"$file\",\"sha256\":\"$sha\"}"
+done
+
+cat <<JSON
+{"bundles":[{"name":"zerok-java","version":"$VERSION","language":"java","files":[$files],"argv":{"binaries":["java"],"options":["-javaagent:{{agent}}/opentelemetry-javaagent.jar","-Dotel.javaagent.extensions={{agent}}/zk-otel-extension.jar"]},"argvEnv":[{"name":"JAVA_TOOL_OPTIONS","value":"-javaagent:{{agent}}/opentelemetry-javaagent.jar -Dotel.javaagent.extensions={{agent}}/zk-otel-extension.jar","separator":" "}]}]}
+JSON
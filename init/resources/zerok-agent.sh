@@ -1,3 +1,5 @@
+#!/bin/sh
+
 echo "Agent Injected successfully."
 echo
 echo "OS details:"
@@ -22,6 +24,17 @@ ls
 
 echo "--------------------"
 
+# The injector prepares the argv of the container and passes it after "--".
+if [ "$1" = "--" ]
+then
+    shift
+    echo "$@"
+    exec "$@"
+fi
+
+# Containers injected by older versions of the injector pass the command as words, with the java agent
+# options still to be added.
+
 final_cmd=""
 agent_options="-javaagent:/opt/zerok/opentelemetry-javaagent.jar -Dotel.javaagent.extensions=/opt/zerok/zk-otel-extension.jar"
 
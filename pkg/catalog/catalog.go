@@ -0,0 +1,199 @@
+package catalog
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Label of the init image holding the manifest of the bundles it ships, as JSON.
+const ManifestLabel = "ai.zerok.agent.manifest"
+
+// Manifest lists the agent bundles shipped by an init image.
+type Manifest struct {
+	Bundles []Bundle `json:"bundles"`
+}
+
+// Bundle is one version of the agent of one language. Paths in files are relative to the agent directory of
+// the init image, the values of env and argv use {{agent}} for the path the directory is mounted at.
+type Bundle struct {
+	Name     string    `json:"name"`
+	Version  string    `json:"version"`
+	Language string    `json:"language"`
+	Files    []File    `json:"files"`
+	Env      []EnvRule `json:"env,omitempty"`
+	Argv     *ArgvRule `json:"argv,omitempty"`
+	// Used instead of argv when the injection mode leaves the command of the container alone.
+	ArgvEnv []EnvRule `json:"argvEnv,omitempty"`
+}
+
+type File struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+}
+
+// EnvRule sets one variable. With a separator the value is merged in front of an existing value, without one
+// an existing value is left alone.
+type EnvRule struct {
+	Name      string `json:"name"`
+	Value     string `json:"value"`
+	Separator string `json:"separator,omitempty"`
+}
+
+// ArgvRule inserts the options right after any of the binaries in the argv of the container.
+type ArgvRule struct {
+	Binaries []string `json:"binaries"`
+	Options  []string `json:"options"`
+}
+
+func (b *Bundle) String() string {
+	return b.Name + "@" + b.Version
+}
+
+type cachedManifest struct {
+	manifest *Manifest
+	version  string
+}
+
+var (
+	manifests      = map[string]cachedManifest{}
+	manifestsMutex sync.Mutex
+)
+
+// LoadFile reads a manifest from a file, usually mounted from a ConfigMap. The manifest is read again only
+// once the modification time or the size of the file changed, which is how kubernetes updates the mount.
+func LoadFile(path string) (*Manifest, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("error caught while reading the agent manifest from %v: %v", path, err)
+	}
+	version := info.ModTime().String() + "/" + strconv.FormatInt(info.Size(), 10)
+	return load("file:"+path, version, func() ([]byte, error) {
+		return os.ReadFile(path)
+	})
+}
+
+// Load parses the manifest returned by read, which is only called the first time the source is loaded. The
+// manifest is kept for as long as the injector runs, so a source should never change its content.
+func Load(source string, read func() ([]byte, error)) (*Manifest, error) {
+	return load(source, "", read)
+}
+
+func load(source string, version string, read func() ([]byte, error)) (*Manifest, error) {
+	manifestsMutex.Lock()
+	defer manifestsMutex.Unlock()
+	if cached, ok := manifests[source]; ok && cached.version == version {
+		return cached.manifest, nil
+	}
+
+	data, err := read()
+	if err != nil {
+		return nil, fmt.Errorf("error caught while reading the agent manifest from %v: %v", source, err)
+	}
+	manifest, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("error caught while parsing the agent manifest from %v: %v", source, err)
+	}
+	manifests[source] = cachedManifest{manifest: manifest, version: version}
+	return manifest, nil
+}
+
+// Parse reads a manifest in YAML or JSON and checks every bundle is complete.
+func Parse(data []byte) (*Manifest, error) {
+	jsonData, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &Manifest{}
+	if err := json.Unmarshal(jsonData, manifest); err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	for _, bundle := range manifest.Bundles {
+		if bundle.Name == "" || bundle.Version == "" || bundle.Language == "" {
+			return nil, fmt.Errorf("bundle %v needs a name, a version and a language", bundle.String())
+		}
+		if len(bundle.Files) == 0 {
+			return nil, fmt.Errorf("bundle %v has no files", bundle.String())
+		}
+		for _, file := range bundle.Files {
+			if file.Path == "" || strings.HasPrefix(file.Path, "/") || strings.Contains(file.Path, "..") {
+				return nil, fmt.Errorf("bundle %v has an invalid file path %q", bundle.String(), file.Path)
+			}
+			if len(file.SHA256) != 64 {
+				return nil, fmt.Errorf("bundle %v has an invalid sha256 for %v", bundle.String(), file.Path)
+			}
+		}
+		key := bundle.Language + "/" + bundle.Version
+		if seen[key] {
+			return nil, fmt.Errorf("more than one %v bundle with version %v", bundle.Language, bundle.Version)
+		}
+		seen[key] = true
+	}
+	return manifest, nil
+}
+
+// Select picks the bundle of the language with the requested version, or the newest one when no version is
+// requested or the version is "latest".
+func (m *Manifest) Select(language string, version string) (*Bundle, error) {
+	var selected *Bundle
+	for i := range m.Bundles {
+		bundle := &m.Bundles[i]
+		if !strings.EqualFold(bundle.Language, language) {
+			continue
+		}
+		if version != "" && version != "latest" {
+			if bundle.Version == version {
+				return bundle, nil
+			}
+			continue
+		}
+		if selected == nil || compareVersions(bundle.Version, selected.Version) > 0 {
+			selected = bundle
+		}
+	}
+	if selected == nil {
+		if version != "" && version != "latest" {
+			return nil, fmt.Errorf("no %v bundle with version %v", language, version)
+		}
+		return nil, fmt.Errorf("no %v bundle", language)
+	}
+	return selected, nil
+}
+
+// compareVersions compares dotted versions part by part, numerically where both parts are numbers. A leading
+// "v" and pre-release suffixes after "-" are ignored.
+func compareVersions(a string, b string) int {
+	aParts := strings.Split(strings.SplitN(strings.TrimPrefix(a, "v"), "-", 2)[0], ".")
+	bParts := strings.Split(strings.SplitN(strings.TrimPrefix(b, "v"), "-", 2)[0], ".")
+	for i := 0; i < len(aParts) || i < len(bParts); i++ {
+		aPart, bPart := "0", "0"
+		if i < len(aParts) {
+			aPart = aParts[i]
+		}
+		if i < len(bParts) {
+			bPart = bParts[i]
+		}
+		aNumber, aErr := strconv.Atoi(aPart)
+		bNumber, bErr := strconv.Atoi(bPart)
+		if aErr == nil && bErr == nil {
+			if aNumber != bNumber {
+				if aNumber < bNumber {
+					return -1
+				}
+				return 1
+			}
+			continue
+		}
+		if comparison := strings.Compare(aPart, bPart); comparison != 0 {
+			return comparison
+		}
+	}
+	return 0
+}
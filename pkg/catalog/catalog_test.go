@@ -0,0 +1,128 @@
+package catalog
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testSHA256 = strings.Repeat("a", 64)
+
+func getTestManifest(bundles string) []byte {
+	return []byte("bundles:\n" + bundles)
+}
+
+func getTestBundle(language string, version string, path string, sha256 string) string {
+	return "- name: " + language + "-agent\n" +
+		"  version: \"" + version + "\"\n" +
+		"  language: " + language + "\n" +
+		"  files:\n" +
+		"  - path: " + path + "\n" +
+		"    sha256: " + sha256 + "\n"
+}
+
+func TestParse(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		bundles string
+		valid   bool
+	}{
+		{"valid", getTestBundle("java", "1.0", "java/agent.jar", testSHA256), true},
+		{"parent path", getTestBundle("java", "1.0", "../agent.jar", testSHA256), false},
+		{"nested parent path", getTestBundle("java", "1.0", "java/../../agent.jar", testSHA256), false},
+		{"absolute path", getTestBundle("java", "1.0", "/opt/agent.jar", testSHA256), false},
+		{"short sha256", getTestBundle("java", "1.0", "java/agent.jar", testSHA256[1:]), false},
+		{"long sha256", getTestBundle("java", "1.0", "java/agent.jar", testSHA256+"a"), false},
+		{"duplicate version", getTestBundle("java", "1.0", "java/agent.jar", testSHA256) + getTestBundle("java", "1.0", "java/other.jar", testSHA256), false},
+		{"same version of two languages", getTestBundle("java", "1.0", "java/agent.jar", testSHA256) + getTestBundle("nodejs", "1.0", "nodejs", testSHA256), true},
+		{"no files", "- name: java-agent\n  version: \"1.0\"\n  language: java\n", false},
+		{"no version", "- name: java-agent\n  language: java\n  files:\n  - path: agent.jar\n    sha256: " + testSHA256 + "\n", false},
+	} {
+		_, err := Parse(getTestManifest(test.bundles))
+		if (err == nil) != test.valid {
+			t.Errorf("%v: expected valid %v, got %v", test.name, test.valid, err)
+		}
+	}
+}
+
+func TestSelect(t *testing.T) {
+	manifest, err := Parse(getTestManifest(
+		getTestBundle("java", "1.9", "java/1.9.jar", testSHA256) +
+			getTestBundle("java", "v1.10", "java/1.10.jar", testSHA256) +
+			getTestBundle("java", "1.10.1-rc.1", "java/1.10.1.jar", testSHA256) +
+			getTestBundle("nodejs", "2.0", "nodejs", testSHA256),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		language string
+		version  string
+		expected string
+	}{
+		{"java", "", "1.10.1-rc.1"},
+		{"java", "latest", "1.10.1-rc.1"},
+		{"Java", "1.9", "1.9"},
+		{"java", "v1.10", "v1.10"},
+		{"java", "1.10", ""},
+		{"java", "2.0", ""},
+		{"python", "", ""},
+	} {
+		bundle, err := manifest.Select(test.language, test.version)
+		if test.expected == "" {
+			if err == nil {
+				t.Errorf("Select(%v, %v): expected no bundle, got %v", test.language, test.version, bundle.String())
+			}
+			continue
+		}
+		if err != nil || bundle.Version != test.expected {
+			t.Errorf("Select(%v, %v): expected version %v, got %v, %v", test.language, test.version, test.expected, bundle, err)
+		}
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	for _, test := range []struct {
+		a        string
+		b        string
+		expected int
+	}{
+		{"1.10", "1.9", 1},
+		{"1.9", "1.10", -1},
+		{"v1.2.0", "1.2", 0},
+		{"1.2.0-rc.1", "1.2.0", 0},
+		{"1.2.1-rc.1", "1.2.0", 1},
+		{"2", "1.99.99", 1},
+		{"1.0.beta", "1.0.alpha", 1},
+	} {
+		if comparison := compareVersions(test.a, test.b); comparison != test.expected {
+			t.Errorf("compareVersions(%v, %v) = %v, expected %v", test.a, test.b, comparison, test.expected)
+		}
+	}
+}
+
+func TestLoadFileRereadsAChangedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "manifest.yaml")
+	if err := os.WriteFile(path, getTestManifest(getTestBundle("java", "1.0", "java/agent.jar", testSHA256)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := LoadFile(path)
+	if err != nil || manifest.Bundles[0].Version != "1.0" {
+		t.Fatalf("expected version 1.0, got %+v, %v", manifest, err)
+	}
+
+	if err := os.WriteFile(path, getTestManifest(getTestBundle("java", "2.0", "java/agent.jar", testSHA256)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// The content changed without changing the size, so only the modification time tells.
+	if err := os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err = LoadFile(path)
+	if err != nil || manifest.Bundles[0].Version != "2.0" {
+		t.Fatalf("expected version 2.0 once the file changed, got %+v, %v", manifest, err)
+	}
+}
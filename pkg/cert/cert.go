@@ -0,0 +1,227 @@
+package cert
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+var (
+	caValidity     = 365 * 24 * time.Hour
+	serverValidity = 7 * 24 * time.Hour
+	// Certificates are backdated a little, so that nodes with a clock slightly behind accept them.
+	clockSkew = 5 * time.Minute
+)
+
+// Bundle is the CA of the webhook with the serving certificate it signed, all PEM encoded. The CA bundle
+// holds the current CA and, while a rotation is in progress, the previous one.
+type Bundle struct {
+	CACert     []byte
+	CAKey      []byte
+	CABundle   []byte
+	ServerCert []byte
+	ServerKey  []byte
+	// When the current CA was added to the CA bundle, zero when the CA was never rotated.
+	RotatedAt time.Time
+}
+
+// KeyPair returns the serving certificate for the tls server.
+func (b *Bundle) KeyPair() (tls.Certificate, error) {
+	return tls.X509KeyPair(b.ServerCert, b.ServerKey)
+}
+
+// verifyServerCert checks the serving certificate chains to one of the CAs of the bundle, covers the dns names
+// and stays valid for longer than the given duration.
+func (b *Bundle) verifyServerCert(dnsNames []string, validFor time.Duration) error {
+	if _, err := b.KeyPair(); err != nil {
+		return fmt.Errorf("invalid server key pair: %v", err)
+	}
+	serverCert, err := parseCertificate(b.ServerCert)
+	if err != nil {
+		return fmt.Errorf("invalid server certificate: %v", err)
+	}
+	if time.Until(serverCert.NotAfter) < validFor {
+		return fmt.Errorf("server certificate expires at %v", serverCert.NotAfter)
+	}
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(b.CABundle) {
+		return fmt.Errorf("invalid ca bundle")
+	}
+	for _, dnsName := range dnsNames {
+		if _, err := serverCert.Verify(x509.VerifyOptions{DNSName: dnsName, Roots: roots}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isSignedByCurrentCA tells whether the serving certificate was issued by the current CA rather than the one
+// being rotated out.
+func (b *Bundle) isSignedByCurrentCA() bool {
+	caCert, err := parseCertificate(b.CACert)
+	if err != nil {
+		return false
+	}
+	serverCert, err := parseCertificate(b.ServerCert)
+	if err != nil {
+		return false
+	}
+	return serverCert.CheckSignatureFrom(caCert) == nil
+}
+
+func parseCertificate(certPEM []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no certificate found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func parseCAKeyPair(caCertPEM []byte, caKeyPEM []byte) (*x509.Certificate, *rsa.PrivateKey, error) {
+	caCert, err := parseCertificate(caCertPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+	block, _ := pem.Decode(caKeyPEM)
+	if block == nil || block.Type != "RSA PRIVATE KEY" {
+		return nil, nil, fmt.Errorf("no ca key found")
+	}
+	caKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !caCert.IsCA {
+		return nil, nil, fmt.Errorf("certificate is not a ca")
+	}
+	return caCert, caKey, nil
+}
+
+// joinCABundle puts the CAs together, dropping duplicates and the ones which expired or cannot be parsed.
+func joinCABundle(caCerts ...[]byte) []byte {
+	caBundle := new(bytes.Buffer)
+	seen := map[string]bool{}
+	for _, caCertPEM := range caCerts {
+		rest := caCertPEM
+		for {
+			var block *pem.Block
+			block, rest = pem.Decode(rest)
+			if block == nil {
+				break
+			}
+			caCert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil || time.Now().After(caCert.NotAfter) || seen[string(block.Bytes)] {
+				continue
+			}
+			seen[string(block.Bytes)] = true
+			_ = pem.Encode(caBundle, block)
+		}
+	}
+	return caBundle.Bytes()
+}
+
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+func generateCA(orgs []string) ([]byte, []byte, error) {
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	ca := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "zk-injector-ca-" + now.UTC().Format("20060102150405"), Organization: orgs},
+		NotBefore:             now.Add(-clockSkew),
+		NotAfter:              now.Add(caValidity),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+
+	caPrivateKey, err := rsa.GenerateKey(rand.Reader, 4096)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	caBytes, err := x509.CreateCertificate(rand.Reader, ca, ca, &caPrivateKey.PublicKey, caPrivateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	caPEM := new(bytes.Buffer)
+	_ = pem.Encode(caPEM, &pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: caBytes,
+	})
+
+	caPrivateKeyPEM := new(bytes.Buffer)
+	_ = pem.Encode(caPrivateKeyPEM, &pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(caPrivateKey),
+	})
+
+	return caPEM.Bytes(), caPrivateKeyPEM.Bytes(), nil
+}
+
+// generateServerCert issues a short lived serving certificate, which never outlives the CA signing it.
+func generateServerCert(orgs, dnsNames []string, commonName string, caCertPEM []byte, caKeyPEM []byte) ([]byte, []byte, error) {
+	parentCa, parentPrivateKey, err := parseCAKeyPair(caCertPEM, caKeyPEM)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid ca: %v", err)
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	notAfter := now.Add(serverValidity)
+	if notAfter.After(parentCa.NotAfter) {
+		notAfter = parentCa.NotAfter
+	}
+	serverCert := &x509.Certificate{
+		DNSNames:     dnsNames,
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName:   commonName,
+			Organization: orgs,
+		},
+		NotBefore:   now.Add(-clockSkew),
+		NotAfter:    notAfter,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+	}
+
+	serverPrivateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serverCertBytes, err := x509.CreateCertificate(rand.Reader, serverCert, parentCa, &serverPrivateKey.PublicKey, parentPrivateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serverCertPEM := new(bytes.Buffer)
+	_ = pem.Encode(serverCertPEM, &pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: serverCertBytes,
+	})
+
+	serverPrivateKeyPEM := new(bytes.Buffer)
+	_ = pem.Encode(serverPrivateKeyPEM, &pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(serverPrivateKey),
+	})
+
+	return serverCertPEM.Bytes(), serverPrivateKeyPEM.Bytes(), nil
+}
@@ -0,0 +1,224 @@
+package cert
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+var (
+	// A new CA is added to the CA bundle this long before the current one expires.
+	caRenewBefore = 90 * 24 * time.Hour
+	// Serving certificates are renewed this long before they expire.
+	serverRenewBefore = 2 * 24 * time.Hour
+	// Time given to the api servers to pick up a new CA bundle before certificates of the new CA are served.
+	caPropagationDelay = 10 * time.Minute
+	checkInterval      = 5 * time.Minute
+)
+
+// Manager keeps the webhook certificates in a secret shared by all replicas. It renews the serving
+// certificate before it expires and rotates the CA by publishing a CA bundle with both the old and the new CA,
+// only serving certificates of the new CA once the bundle had time to propagate. The current certificate is
+// served through GetCertificate, so rotations need no restart.
+type Manager struct {
+	clientSet  kubernetes.Interface
+	namespace  string
+	secretName string
+	orgs       []string
+	dnsNames   []string
+	commonName string
+	// Called with the CA bundle whenever it changes, to update the webhook configuration.
+	publishCABundle func(caBundle []byte) error
+
+	mutex             sync.RWMutex
+	certificate       *tls.Certificate
+	publishedCABundle []byte
+}
+
+func NewManager(clientSet kubernetes.Interface, namespace string, secretName string, orgs, dnsNames []string, commonName string, publishCABundle func(caBundle []byte) error) *Manager {
+	return &Manager{
+		clientSet:       clientSet,
+		namespace:       namespace,
+		secretName:      secretName,
+		orgs:            orgs,
+		dnsNames:        dnsNames,
+		commonName:      commonName,
+		publishCABundle: publishCABundle,
+	}
+}
+
+// Start loads the certificates, creating them when needed, and keeps checking them in the background until
+// the stop channel is closed.
+func (m *Manager) Start(stopCh <-chan struct{}) error {
+	if err := m.sync(); err != nil {
+		return err
+	}
+	go func() {
+		ticker := time.NewTicker(checkInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-ticker.C:
+				if err := m.sync(); err != nil {
+					fmt.Printf("Error caught while checking the webhook certificates: %v.\n", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// GetCertificate serves the current certificate, for tls.Config.
+func (m *Manager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	if m.certificate == nil {
+		return nil, fmt.Errorf("no webhook certificate loaded")
+	}
+	return m.certificate, nil
+}
+
+// sync reads the secret, renews what needs renewing and stores it back. Replicas race on the secret through
+// its resource version: the one losing reads the secret again and uses what the other one stored.
+func (m *Manager) sync() error {
+	secrets := m.clientSet.CoreV1().Secrets(m.namespace)
+	for attempt := 0; attempt < 3; attempt++ {
+		secret, err := secrets.Get(context.TODO(), m.secretName, metav1.GetOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("error caught while getting the secret %v/%v: %v", m.namespace, m.secretName, err)
+		}
+		exists := err == nil
+		if !exists {
+			secret = &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      m.secretName,
+					Namespace: m.namespace,
+					Labels:    map[string]string{"app": "zk-injector"},
+				},
+				Type: corev1.SecretTypeTLS,
+			}
+		}
+
+		bundle := fromSecret(secret)
+		changed, err := m.renew(bundle)
+		if err != nil {
+			return err
+		}
+
+		if changed {
+			toSecret(bundle, secret)
+			if exists {
+				_, err = secrets.Update(context.TODO(), secret, metav1.UpdateOptions{})
+			} else {
+				_, err = secrets.Create(context.TODO(), secret, metav1.CreateOptions{})
+			}
+			if apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err) {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("error caught while storing the secret %v/%v: %v", m.namespace, m.secretName, err)
+			}
+			fmt.Printf("Stored renewed webhook certificates in the secret %v/%v.\n", m.namespace, m.secretName)
+		}
+
+		return m.load(bundle)
+	}
+	return fmt.Errorf("the secret %v/%v kept changing while renewing the certificates", m.namespace, m.secretName)
+}
+
+// renew brings the bundle up to date, telling whether anything changed.
+func (m *Manager) renew(bundle *Bundle) (bool, error) {
+	changed := false
+	issueServerCert := false
+
+	caCert, _, err := parseCAKeyPair(bundle.CACert, bundle.CAKey)
+	if err != nil {
+		// Nothing trusts a CA which is not there, so its serving certificate is issued right away.
+		fmt.Printf("No usable webhook CA, creating one: %v.\n", err)
+		if bundle.CACert, bundle.CAKey, err = generateCA(m.orgs); err != nil {
+			return false, fmt.Errorf("error caught while generating the webhook ca: %v", err)
+		}
+		bundle.CABundle = joinCABundle(bundle.CACert)
+		bundle.RotatedAt = time.Time{}
+		changed = true
+		issueServerCert = true
+	} else if time.Until(caCert.NotAfter) < caRenewBefore {
+		fmt.Printf("The webhook CA expires at %v, rotating it.\n", caCert.NotAfter)
+		if bundle.CACert, bundle.CAKey, err = generateCA(m.orgs); err != nil {
+			return false, fmt.Errorf("error caught while generating the webhook ca: %v", err)
+		}
+		bundle.CABundle = joinCABundle(bundle.CACert, bundle.CABundle)
+		bundle.RotatedAt = time.Now()
+		changed = true
+	}
+
+	// The previous CA stays in the bundle until it expires, so certificates it signed keep working.
+	if caBundle := joinCABundle(bundle.CACert, bundle.CABundle); !bytes.Equal(caBundle, bundle.CABundle) {
+		bundle.CABundle = caBundle
+		changed = true
+	}
+
+	if err := bundle.verifyServerCert(m.dnsNames, serverRenewBefore); err != nil {
+		fmt.Printf("Renewing the webhook serving certificate: %v.\n", err)
+		issueServerCert = true
+	} else if serverCert, _ := parseCertificate(bundle.ServerCert); serverCert.NotAfter.Sub(serverCert.NotBefore) > serverValidity+clockSkew {
+		fmt.Println("Replacing the long lived webhook serving certificate.")
+		issueServerCert = true
+	} else if !bundle.isSignedByCurrentCA() && time.Since(bundle.RotatedAt) > caPropagationDelay {
+		// Until the bundle holding the new CA is published the webhook configuration only trusts the old one.
+		m.mutex.RLock()
+		published := bytes.Equal(m.publishedCABundle, bundle.CABundle)
+		m.mutex.RUnlock()
+		if published {
+			fmt.Println("Moving the webhook serving certificate to the new CA.")
+			issueServerCert = true
+		} else {
+			fmt.Println("Keeping the webhook serving certificate of the old CA until the new CA bundle is published.")
+		}
+	}
+
+	if issueServerCert {
+		if bundle.ServerCert, bundle.ServerKey, err = generateServerCert(m.orgs, m.dnsNames, m.commonName, bundle.CACert, bundle.CAKey); err != nil {
+			return false, fmt.Errorf("error caught while generating the webhook serving certificate: %v", err)
+		}
+		changed = true
+	}
+	return changed, nil
+}
+
+// load publishes the CA bundle when it changed and starts serving the certificate of the bundle. A failed
+// publish is retried on the next check.
+func (m *Manager) load(bundle *Bundle) error {
+	certificate, err := bundle.KeyPair()
+	if err != nil {
+		return fmt.Errorf("error caught while loading the webhook serving certificate: %v", err)
+	}
+
+	m.mutex.RLock()
+	published := bytes.Equal(m.publishedCABundle, bundle.CABundle)
+	m.mutex.RUnlock()
+	if !published {
+		if err := m.publishCABundle(bundle.CABundle); err != nil {
+			fmt.Printf("Error caught while publishing the webhook CA bundle: %v.\n", err)
+		} else {
+			m.mutex.Lock()
+			m.publishedCABundle = bundle.CABundle
+			m.mutex.Unlock()
+		}
+	}
+
+	m.mutex.Lock()
+	m.certificate = &certificate
+	m.mutex.Unlock()
+	return nil
+}
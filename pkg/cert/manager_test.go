@@ -0,0 +1,189 @@
+package cert
+
+import (
+	"bytes"
+	"context"
+	"encoding/pem"
+	"fmt"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const (
+	testNamespace  = "zk-injector"
+	testSecretName = "zk-injector-certs"
+)
+
+var testDNSNames = []string{"zk-injector", "zk-injector.zk-injector", "zk-injector.zk-injector.svc"}
+
+func newTestManager(clientSet kubernetes.Interface, published *[][]byte) *Manager {
+	return NewManager(clientSet, testNamespace, testSecretName, []string{"zerok"}, testDNSNames, "zk-injector.zk-injector.svc", func(caBundle []byte) error {
+		*published = append(*published, caBundle)
+		return nil
+	})
+}
+
+func countCertificates(caBundle []byte) int {
+	count := 0
+	for rest := caBundle; ; count++ {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			return count
+		}
+	}
+}
+
+func getTestBundle(t *testing.T, clientSet kubernetes.Interface) *Bundle {
+	t.Helper()
+	secret, err := clientSet.CoreV1().Secrets(testNamespace).Get(context.TODO(), testSecretName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fromSecret(secret)
+}
+
+func TestReplicasShareTheCertificates(t *testing.T) {
+	clientSet := fake.NewSimpleClientset()
+	var published [][]byte
+	first := newTestManager(clientSet, &published)
+	second := newTestManager(clientSet, &published)
+	if err := first.sync(); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	firstCertificate, _ := first.GetCertificate(nil)
+	secondCertificate, _ := second.GetCertificate(nil)
+	if !bytes.Equal(firstCertificate.Certificate[0], secondCertificate.Certificate[0]) {
+		t.Fatal("expected both replicas to serve the certificate stored in the secret")
+	}
+	if len(published) != 2 || !bytes.Equal(published[0], published[1]) || countCertificates(published[0]) != 1 {
+		t.Fatalf("expected both replicas to publish the same single CA, got %v bundles", len(published))
+	}
+	if err := getTestBundle(t, clientSet).verifyServerCert(testDNSNames, serverValidity-time.Hour); err != nil {
+		t.Fatalf("expected a valid serving certificate: %v", err)
+	}
+}
+
+func TestCARotationKeepsBothCAsUntilPropagated(t *testing.T) {
+	clientSet := fake.NewSimpleClientset()
+	var published [][]byte
+	manager := newTestManager(clientSet, &published)
+	if err := manager.sync(); err != nil {
+		t.Fatal(err)
+	}
+	oldBundle := getTestBundle(t, clientSet)
+
+	// Every CA is due for renewal, once.
+	defaultCARenewBefore := caRenewBefore
+	caRenewBefore = caValidity + time.Hour
+	err := manager.sync()
+	caRenewBefore = defaultCARenewBefore
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated := getTestBundle(t, clientSet)
+	if bytes.Equal(rotated.CACert, oldBundle.CACert) || rotated.RotatedAt.IsZero() {
+		t.Fatal("expected a new CA")
+	}
+	if countCertificates(rotated.CABundle) != 2 || countCertificates(published[len(published)-1]) != 2 {
+		t.Fatal("expected the old and the new CA to be published together")
+	}
+	if !bytes.Equal(rotated.ServerCert, oldBundle.ServerCert) || rotated.isSignedByCurrentCA() {
+		t.Fatal("expected the serving certificate of the old CA until the new CA bundle propagated")
+	}
+
+	defaultCAPropagationDelay := caPropagationDelay
+	caPropagationDelay = 0
+	err = manager.sync()
+	caPropagationDelay = defaultCAPropagationDelay
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	moved := getTestBundle(t, clientSet)
+	if !moved.isSignedByCurrentCA() {
+		t.Fatal("expected the serving certificate to move to the new CA")
+	}
+	if countCertificates(moved.CABundle) != 2 {
+		t.Fatal("expected the old CA to stay in the bundle until it expires")
+	}
+	served, _ := manager.GetCertificate(nil)
+	expected, _ := moved.KeyPair()
+	if !bytes.Equal(served.Certificate[0], expected.Certificate[0]) {
+		t.Fatal("expected the new serving certificate to be served without a restart")
+	}
+}
+
+func TestCARotationWaitsForThePublish(t *testing.T) {
+	clientSet := fake.NewSimpleClientset()
+	var published [][]byte
+	manager := newTestManager(clientSet, &published)
+	if err := manager.sync(); err != nil {
+		t.Fatal(err)
+	}
+	oldBundle := getTestBundle(t, clientSet)
+	manager.publishCABundle = func(caBundle []byte) error {
+		return fmt.Errorf("webhook configuration unavailable")
+	}
+
+	defaultCARenewBefore := caRenewBefore
+	caRenewBefore = caValidity + time.Hour
+	err := manager.sync()
+	caRenewBefore = defaultCARenewBefore
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defaultCAPropagationDelay := caPropagationDelay
+	caPropagationDelay = 0
+	defer func() {
+		caPropagationDelay = defaultCAPropagationDelay
+	}()
+	if err := manager.sync(); err != nil {
+		t.Fatal(err)
+	}
+	served, _ := manager.GetCertificate(nil)
+	expected, _ := oldBundle.KeyPair()
+	if !bytes.Equal(served.Certificate[0], expected.Certificate[0]) {
+		t.Fatal("expected the serving certificate of the old CA while the new CA bundle is not published")
+	}
+	if getTestBundle(t, clientSet).isSignedByCurrentCA() {
+		t.Fatal("expected the stored serving certificate to stay with the old CA")
+	}
+
+	manager.publishCABundle = func(caBundle []byte) error {
+		published = append(published, caBundle)
+		return nil
+	}
+	if err := manager.sync(); err != nil {
+		t.Fatal(err)
+	}
+	if countCertificates(published[len(published)-1]) != 2 {
+		t.Fatal("expected the publish of both CAs to be retried")
+	}
+	if err := manager.sync(); err != nil {
+		t.Fatal(err)
+	}
+	if !getTestBundle(t, clientSet).isSignedByCurrentCA() {
+		t.Fatal("expected the serving certificate to move to the new CA once published")
+	}
+}
+
+func TestJoinCABundleDropsDuplicates(t *testing.T) {
+	caCert, _, err := generateCA([]string{"zerok"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	caBundle := joinCABundle(caCert, caCert, joinCABundle(caCert), []byte("not a certificate"))
+	if countCertificates(caBundle) != 1 {
+		t.Fatalf("expected a single CA, got %v", countCertificates(caBundle))
+	}
+}
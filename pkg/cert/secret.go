@@ -0,0 +1,46 @@
+package cert
+
+import (
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	caCertKey   = "ca.crt"
+	caKeyKey    = "ca.key"
+	caBundleKey = "ca-bundle.crt"
+	// When the current CA was added to the CA bundle, in RFC 3339.
+	rotatedAtAnnotation = "zerok.ai/ca-rotated-at"
+)
+
+func fromSecret(secret *corev1.Secret) *Bundle {
+	bundle := &Bundle{
+		CACert:     secret.Data[caCertKey],
+		CAKey:      secret.Data[caKeyKey],
+		CABundle:   secret.Data[caBundleKey],
+		ServerCert: secret.Data[corev1.TLSCertKey],
+		ServerKey:  secret.Data[corev1.TLSPrivateKeyKey],
+	}
+	if rotatedAt, err := time.Parse(time.RFC3339, secret.Annotations[rotatedAtAnnotation]); err == nil {
+		bundle.RotatedAt = rotatedAt
+	}
+	return bundle
+}
+
+func toSecret(bundle *Bundle, secret *corev1.Secret) {
+	secret.Data = map[string][]byte{
+		caCertKey:               bundle.CACert,
+		caKeyKey:                bundle.CAKey,
+		caBundleKey:             bundle.CABundle,
+		corev1.TLSCertKey:       bundle.ServerCert,
+		corev1.TLSPrivateKeyKey: bundle.ServerKey,
+	}
+	if secret.Annotations == nil {
+		secret.Annotations = map[string]string{}
+	}
+	delete(secret.Annotations, rotatedAtAnnotation)
+	if !bundle.RotatedAt.IsZero() {
+		secret.Annotations[rotatedAtAnnotation] = bundle.RotatedAt.UTC().Format(time.RFC3339)
+	}
+}
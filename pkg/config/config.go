@@ -0,0 +1,332 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	configPathEnv     = "ZK_INJECTOR_CONFIG"
+	defaultConfigPath = "/etc/zk-injector/config.yaml"
+)
+
+type InjectorConfig struct {
+	// How the agent is attached to the application containers, see InjectionMode*.
+	InjectionMode string `json:"injectionMode,omitempty"`
+	// Classify the runtime of containers without a zerok.ai/language annotation from their image, only
+	// instrumenting the ones with a supported runtime. The image is pulled for it, except in the
+	// java-tool-options mode, which only looks at the container spec.
+	DetectRuntime bool `json:"detectRuntime"`
+	// Language of the agent for containers without a zerok.ai/language annotation, when DetectRuntime is off.
+	DefaultLanguage string `json:"defaultLanguage,omitempty"`
+	// Languages whose built in agent the init image ships. Containers of other languages are left alone. With a
+	// catalog the languages of its bundles are used instead.
+	Languages     []string            `json:"languages,omitempty"`
+	InitContainer InitContainerConfig `json:"initContainer,omitempty"`
+	// How the agent files reach the pods, see Delivery*.
+	Delivery string `json:"delivery,omitempty"`
+	// Image holding the agent files at its root, mounted as a volume with image volume delivery. Pods get the
+	// init container while no image is set.
+	AgentImage AgentImageConfig `json:"agentImage,omitempty"`
+	Exporter   ExporterConfig   `json:"exporter,omitempty"`
+	// Resources added to the instrumented containers for the agent, per language.
+	Overhead map[string]OverheadPolicy `json:"overhead,omitempty"`
+	// Containers added by service meshes and other injectors, never instrumented unless the pod names them in
+	// zerok.ai/inject-containers.
+	MeshContainers []string `json:"meshContainers,omitempty"`
+	// Settings of the java agent, extended by the zerok.ai/java-* annotations of the pods.
+	Java JavaConfig `json:"java,omitempty"`
+	// Where the versioned agent bundles come from. Without a catalog the agents built into the injector are used.
+	Catalog CatalogConfig `json:"catalog,omitempty"`
+	// Pods and containers which are never injected, on top of the built in rules.
+	Exclusions ExclusionConfig `json:"exclusions,omitempty"`
+
+	// Per namespace overrides, merged field by field on top of the rest of the configuration.
+	Namespaces map[string]json.RawMessage `json:"namespaces,omitempty"`
+}
+
+type InitContainerConfig struct {
+	Image           string                      `json:"image,omitempty"`
+	Digest          string                      `json:"digest,omitempty"`
+	ImagePullPolicy corev1.PullPolicy           `json:"imagePullPolicy,omitempty"`
+	Resources       corev1.ResourceRequirements `json:"resources,omitempty"`
+	SecurityContext *corev1.SecurityContext     `json:"securityContext,omitempty"`
+	VolumeMedium    corev1.StorageMedium        `json:"volumeMedium,omitempty"`
+	SizeLimit       *resource.Quantity          `json:"sizeLimit,omitempty"`
+
+	// Placement of the init container, either "first" or "last". With "first" it still runs after the service
+	// mesh init containers at the start of the list, so the mesh ordering is kept.
+	Placement          string   `json:"placement,omitempty"`
+	MeshInitContainers []string `json:"meshInitContainers,omitempty"`
+
+	// Copy the whole agent directory with cp, for init images built before the zk-copy copier.
+	LegacyCopy bool `json:"legacyCopy,omitempty"`
+}
+
+type AgentImageConfig struct {
+	Image           string            `json:"image,omitempty"`
+	Digest          string            `json:"digest,omitempty"`
+	ImagePullPolicy corev1.PullPolicy `json:"imagePullPolicy,omitempty"`
+}
+
+// ExporterConfig is rendered into the standard OTEL_* variables of the instrumented containers.
+type ExporterConfig struct {
+	Endpoint string `json:"endpoint,omitempty"`
+	// grpc, http/protobuf or http/json.
+	Protocol string `json:"protocol,omitempty"`
+	// Send to a collector on the node of the pod, reached through status.hostIP, instead of the endpoint.
+	NodeLocal     bool              `json:"nodeLocal,omitempty"`
+	NodeLocalPort int               `json:"nodeLocalPort,omitempty"`
+	Headers       map[string]string `json:"headers,omitempty"`
+	// Headers read from secrets in the namespace of the pod, left out where the secret or its key is missing.
+	SecretHeaders []SecretHeader `json:"secretHeaders,omitempty"`
+	Sampler       string         `json:"sampler,omitempty"`
+	SamplerArg    string         `json:"samplerArg,omitempty"`
+	Propagators   []string       `json:"propagators,omitempty"`
+}
+
+// OverheadPolicy raises the requests and limits a container already has by an absolute amount plus a
+// percentage of the current value.
+type OverheadPolicy struct {
+	Memory        *resource.Quantity `json:"memory,omitempty"`
+	MemoryPercent int64              `json:"memoryPercent,omitempty"`
+	CPU           *resource.Quantity `json:"cpu,omitempty"`
+	CPUPercent    int64              `json:"cpuPercent,omitempty"`
+}
+
+type JavaConfig struct {
+	// System properties passed to the JVM, only -Dname=value options are accepted.
+	Options []string `json:"options,omitempty"`
+	// Extension jars loaded by the agent, relative to the agent directory or absolute paths in the image.
+	Extensions []string `json:"extensions,omitempty"`
+	// Instrumentations turned off through otel.instrumentation.<name>.enabled=false.
+	DisabledInstrumentations []string `json:"disabledInstrumentations,omitempty"`
+}
+
+type CatalogConfig struct {
+	// Manifest of the bundles in the init image, usually mounted from a ConfigMap.
+	ManifestPath string `json:"manifestPath,omitempty"`
+	// Read the manifest from the ai.zerok.agent.manifest label of the init image instead.
+	FromImage bool `json:"fromImage,omitempty"`
+	// Version of the bundle to use per language, the newest bundle of the language when not set. Pods may ask
+	// for another version with the zerok.ai/agent-version annotation.
+	Versions map[string]string `json:"versions,omitempty"`
+}
+
+type ExclusionConfig struct {
+	// Kinds of the controller of the pod, or of the workload at the end of its owner chain, like DaemonSet.
+	OwnerKinds []string `json:"ownerKinds,omitempty"`
+	// Glob patterns, as understood by path.Match, matched against the image of each container.
+	Images         []string `json:"images,omitempty"`
+	RuntimeClasses []string `json:"runtimeClasses,omitempty"`
+}
+
+type SecretHeader struct {
+	Name       string `json:"name"`
+	SecretName string `json:"secretName"`
+	Key        string `json:"key"`
+}
+
+const (
+	// Rewrites command and args of the container to start it through the agent script.
+	InjectionModeCommand = "command"
+	// Leaves command and args alone and hands the agent to the JVM through JAVA_TOOL_OPTIONS.
+	InjectionModeJavaToolOptions = "java-tool-options"
+)
+
+const (
+	PlacementFirst = "first"
+	PlacementLast  = "last"
+)
+
+const (
+	// Image volumes where the api server supports them, the init container copy everywhere else. The nodes are
+	// not checked, so they all need to support image volumes too.
+	DeliveryAuto = "auto"
+	// Mounts the agent image read only as a volume. Needs the ImageVolume feature of kubernetes 1.31+ on the
+	// api server and the kubelets, and a container runtime able to mount images.
+	DeliveryImageVolume = "image-volume"
+	// Copies the agent files from the init image into an emptyDir volume.
+	DeliveryInitContainer = "init-container"
+)
+
+// GetImage returns the image reference of the init container, pinned to the digest when one is configured.
+func (c *InitContainerConfig) GetImage() string {
+	return pinImage(c.Image, c.Digest)
+}
+
+// GetImage returns the reference of the agent image, pinned to the digest when one is configured.
+func (c *AgentImageConfig) GetImage() string {
+	return pinImage(c.Image, c.Digest)
+}
+
+func pinImage(image string, digest string) string {
+	if digest == "" {
+		return image
+	}
+	if index := strings.LastIndex(image, "@"); index >= 0 {
+		image = image[:index]
+	}
+	if index := strings.LastIndex(image, ":"); index > strings.LastIndex(image, "/") {
+		image = image[:index]
+	}
+	return image + "@" + digest
+}
+
+func Default() *InjectorConfig {
+	return &InjectorConfig{
+		InjectionMode:   InjectionModeCommand,
+		DetectRuntime:   true,
+		DefaultLanguage: "java",
+		// The init image only ships the java agent so far.
+		Languages: []string{"java"},
+		InitContainer: InitContainerConfig{
+			Image:           "rajeevzerok/init-container:latest",
+			ImagePullPolicy: corev1.PullAlways,
+			Placement:       PlacementFirst,
+			// The published init image still predates zk-copy.
+			LegacyCopy: true,
+			MeshInitContainers: []string{
+				"istio-init",
+				"istio-validation",
+				"istio-proxy",
+				"linkerd-init",
+				"linkerd-network-validator",
+				"linkerd-proxy",
+			},
+		},
+		// Image volumes also need support on every node, which cannot be checked from the api server.
+		Delivery: DeliveryInitContainer,
+		MeshContainers: []string{
+			"istio-proxy",
+			"linkerd-proxy",
+			"vault-agent",
+			"vault-agent-init",
+			"consul-dataplane",
+			"envoy-sidecar",
+			"cloud-sql-proxy",
+		},
+	}
+}
+
+var (
+	current          = Default()
+	namespaceConfigs = map[string]*InjectorConfig{}
+)
+
+// Load reads the injector configuration from the file in ZK_INJECTOR_CONFIG, falling back to the defaults
+// when the file does not exist.
+func Load() error {
+	path := os.Getenv(configPathEnv)
+	if path == "" {
+		path = defaultConfigPath
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			fmt.Printf("No injector config found at %v, using the defaults.\n", path)
+			return nil
+		}
+		return fmt.Errorf("error caught while reading the injector config %v: %v", path, err)
+	}
+
+	injectorConfig, overrides, err := parse(data)
+	if err != nil {
+		return fmt.Errorf("error caught while parsing the injector config %v: %v", path, err)
+	}
+
+	current = injectorConfig
+	namespaceConfigs = overrides
+	fmt.Printf("Loaded the injector config from %v.\n", path)
+	return nil
+}
+
+func parse(data []byte) (*InjectorConfig, map[string]*InjectorConfig, error) {
+	jsonData, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	injectorConfig := Default()
+	if err := json.Unmarshal(jsonData, injectorConfig); err != nil {
+		return nil, nil, err
+	}
+
+	overrides := map[string]*InjectorConfig{}
+	for namespace, override := range injectorConfig.Namespaces {
+		namespaceConfig, err := merge(injectorConfig, override)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid override for namespace %v: %v", namespace, err)
+		}
+		overrides[namespace] = namespaceConfig
+	}
+
+	return injectorConfig, overrides, nil
+}
+
+func Get() *InjectorConfig {
+	return current
+}
+
+// ForNamespace returns the configuration with the overrides of the namespace applied.
+func ForNamespace(namespace string) *InjectorConfig {
+	if namespaceConfig, ok := namespaceConfigs[namespace]; ok {
+		return namespaceConfig
+	}
+	return current
+}
+
+func merge(base *InjectorConfig, override json.RawMessage) (*InjectorConfig, error) {
+	baseTree, err := toMap(base)
+	if err != nil {
+		return nil, err
+	}
+	overrideTree := map[string]interface{}{}
+	if err := json.Unmarshal(override, &overrideTree); err != nil {
+		return nil, err
+	}
+	delete(baseTree, "namespaces")
+	delete(overrideTree, "namespaces")
+
+	mergedData, err := json.Marshal(mergeMaps(baseTree, overrideTree))
+	if err != nil {
+		return nil, err
+	}
+	merged := &InjectorConfig{}
+	if err := json.Unmarshal(mergedData, merged); err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
+
+func toMap(v interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	tree := map[string]interface{}{}
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+func mergeMaps(base map[string]interface{}, override map[string]interface{}) map[string]interface{} {
+	for key, overrideValue := range override {
+		baseMap, baseIsMap := base[key].(map[string]interface{})
+		overrideMap, overrideIsMap := overrideValue.(map[string]interface{})
+		if baseIsMap && overrideIsMap {
+			base[key] = mergeMaps(baseMap, overrideMap)
+		} else {
+			base[key] = overrideValue
+		}
+	}
+	return base
+}
@@ -0,0 +1,38 @@
+package inject
+
+import (
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// mergeJSONAnnotation records values per container in an annotation holding a JSON object, on top of what
+// earlier passes recorded for other containers.
+func mergeJSONAnnotation(pod *corev1.Pod, key string, values map[string]interface{}) error {
+	if len(values) == 0 {
+		return nil
+	}
+
+	recorded := map[string]json.RawMessage{}
+	if value, ok := pod.Annotations[key]; ok {
+		_ = json.Unmarshal([]byte(value), &recorded)
+	}
+	for name, value := range values {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Errorf("error caught while marshalling the %v annotation %v", key, err)
+		}
+		recorded[name] = data
+	}
+
+	value, err := json.Marshal(recorded)
+	if err != nil {
+		return fmt.Errorf("error caught while marshalling the %v annotation %v", key, err)
+	}
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations[key] = string(value)
+	return nil
+}
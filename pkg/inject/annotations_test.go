@@ -0,0 +1,31 @@
+package inject
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMergeJSONAnnotationKeepsEarlierPasses(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+		runtimesAnnotation: `{"app":"java","sidecar":{"nested":true}}`,
+	}}}
+	if err := mergeJSONAnnotation(pod, runtimesAnnotation, map[string]interface{}{"app": "nodejs", "worker": "python"}); err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"app":"nodejs","sidecar":{"nested":true},"worker":"python"}`
+	if value := pod.Annotations[runtimesAnnotation]; value != expected {
+		t.Fatalf("expected %v, got %v", expected, value)
+	}
+}
+
+func TestMergeJSONAnnotationWithoutValues(t *testing.T) {
+	pod := &corev1.Pod{}
+	if err := mergeJSONAnnotation(pod, runtimesAnnotation, map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	if pod.Annotations != nil {
+		t.Fatalf("expected no annotations, got %v", pod.Annotations)
+	}
+}
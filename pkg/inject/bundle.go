@@ -0,0 +1,149 @@
+package inject
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/zerok-ai/zerok-injector/pkg/catalog"
+	"github.com/zerok-ai/zerok-injector/pkg/config"
+	"github.com/zerok-ai/zerok-injector/pkg/zkclient"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	agentVersionAnnotation = "zerok.ai/agent-version"
+	agentBundleAnnotation  = "zerok.ai/agent-bundle"
+)
+
+// getManifest returns the manifest of the agent bundles, nil when no catalog is configured.
+func getManifest(injectorConfig *config.InjectorConfig) (*catalog.Manifest, error) {
+	if injectorConfig.Catalog.ManifestPath != "" {
+		return catalog.LoadFile(injectorConfig.Catalog.ManifestPath)
+	}
+	if injectorConfig.Catalog.FromImage {
+		return getImageManifest(injectorConfig.InitContainer.GetImage())
+	}
+	return nil, nil
+}
+
+// getImageManifest reads the manifest from the label of the init image. The manifest is read once per image,
+// so images should be pinned to a digest or a version tag.
+func getImageManifest(image string) (*catalog.Manifest, error) {
+	return catalog.Load("image:"+image, func() ([]byte, error) {
+		imageConfig, err := zkclient.GetImageConfig(image, nil, "", "catalog")
+		if err != nil {
+			return nil, err
+		}
+		value, ok := imageConfig.Labels[catalog.ManifestLabel]
+		if !ok {
+			return nil, fmt.Errorf("image %v has no %v label", image, catalog.ManifestLabel)
+		}
+		return []byte(value), nil
+	})
+}
+
+// getContainerAgentVersion reads the version requested in the zerok.ai/agent-version.<container> annotation,
+// then in the zerok.ai/agent-version annotation of the pod.
+func getContainerAgentVersion(pod *corev1.Pod, containerName string) string {
+	if value, ok := pod.Annotations[agentVersionAnnotation+"."+containerName]; ok {
+		return strings.TrimSpace(value)
+	}
+	return strings.TrimSpace(pod.Annotations[agentVersionAnnotation])
+}
+
+// isLanguageAvailable tells whether the init image ships an agent for the language: a bundle of the catalog,
+// or else one of the built in agents enabled in the configuration. Containers are never pointed at agent
+// files which are not there, since the application would fail to start.
+func isLanguageAvailable(language Language, injectorConfig *config.InjectorConfig) (bool, error) {
+	manifest, err := getManifest(injectorConfig)
+	if err != nil {
+		return false, err
+	}
+	if manifest != nil {
+		for _, bundle := range manifest.Bundles {
+			if strings.EqualFold(bundle.Language, string(language)) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	for _, available := range injectorConfig.Languages {
+		if strings.EqualFold(available, string(language)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// getShippedFiles returns the agent files the init image ships: the files of every bundle of the catalog, or
+// else the files of the built in profile.
+func getShippedFiles(profile *Profile, injectorConfig *config.InjectorConfig) (map[string]bool, error) {
+	manifest, err := getManifest(injectorConfig)
+	if err != nil {
+		return nil, err
+	}
+	files := map[string]bool{}
+	if manifest != nil {
+		for _, bundle := range manifest.Bundles {
+			for _, file := range bundle.Files {
+				files[path.Clean(file.Path)] = true
+			}
+		}
+		return files, nil
+	}
+	for _, file := range profile.Files {
+		files[file] = true
+	}
+	return files, nil
+}
+
+// selectProfile picks the agent for a container: the bundle of the version the pod asks for, or else the one
+// the configuration pins for the language, or else the newest one. Without a catalog the built in profile of
+// the language is used and the bundle is nil.
+func selectProfile(pod *corev1.Pod, containerName string, language Language, injectorConfig *config.InjectorConfig) (*Profile, *catalog.Bundle, error) {
+	manifest, err := getManifest(injectorConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	if manifest == nil {
+		if version := getContainerAgentVersion(pod, containerName); version != "" {
+			return nil, nil, fmt.Errorf("agent version %v requested, but no agent catalog is configured", version)
+		}
+		profile, err := GetProfile(language)
+		return profile, nil, err
+	}
+
+	version := getContainerAgentVersion(pod, containerName)
+	if version == "" {
+		version = injectorConfig.Catalog.Versions[string(language)]
+	}
+	bundle, err := manifest.Select(string(language), version)
+	if err != nil {
+		return nil, nil, err
+	}
+	return getBundleProfile(bundle), bundle, nil
+}
+
+func getBundleProfile(bundle *catalog.Bundle) *Profile {
+	profile := &Profile{
+		Language: Language(strings.ToLower(bundle.Language)),
+		Env:      getEnvRules(bundle.Env),
+		ArgvEnv:  getEnvRules(bundle.ArgvEnv),
+	}
+	for _, file := range bundle.Files {
+		profile.Files = append(profile.Files, file.Path)
+	}
+	if bundle.Argv != nil {
+		profile.Argv = &argvRule{binaries: bundle.Argv.Binaries, options: bundle.Argv.Options}
+	}
+	return profile
+}
+
+func getEnvRules(rules []catalog.EnvRule) []envRule {
+	envRules := make([]envRule, 0, len(rules))
+	for _, rule := range rules {
+		envRules = append(envRules, envRule{name: rule.Name, value: rule.Value, separator: rule.Separator})
+	}
+	return envRules
+}
@@ -0,0 +1,66 @@
+package inject
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/zerok-ai/zerok-injector/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	copierPath = "/usr/local/bin/zk-copy"
+	// Where the agent volume is mounted in the init container.
+	copyTargetPath = "/opt/temp"
+	agentScript    = "zerok-agent.sh"
+)
+
+// getCopiedFiles returns the files the init container of an earlier pass copies, so that the containers it
+// was injected for keep their files when more containers are instrumented. All is true when the whole agent
+// directory is copied.
+func getCopiedFiles(pod *corev1.Pod, names *injectionNames) (map[string]bool, bool) {
+	files := map[string]bool{}
+	index := findInitContainer(pod, names)
+	if index < 0 {
+		return files, false
+	}
+
+	command := pod.Spec.InitContainers[index].Command
+	for i := range command {
+		if command[i] == "-files" && i+1 < len(command) {
+			for _, file := range strings.Split(command[i+1], ",") {
+				files[file] = true
+			}
+			return files, false
+		}
+	}
+	return files, true
+}
+
+// getCopyCommand runs the copier of the init image on the files the instrumented containers need. Nil files
+// copy the whole agent directory. Images older than the copier are handled with a plain copy.
+func getCopyCommand(initContainerConfig *config.InitContainerConfig, files map[string]bool, runAsUser *int64, runAsGroup *int64) []string {
+	if initContainerConfig.LegacyCopy {
+		// The files are made readable for every user, since the application may run as another user than the
+		// init container.
+		return []string{"sh", "-c", "cp -r " + initImageAgentPath + "/. " + copyTargetPath + " && chmod -R a+rX " + copyTargetPath}
+	}
+
+	command := []string{copierPath, "-source", initImageAgentPath, "-target", copyTargetPath}
+	if files != nil {
+		selected := make([]string, 0, len(files))
+		for file := range files {
+			selected = append(selected, file)
+		}
+		sort.Strings(selected)
+		command = append(command, "-files", strings.Join(selected, ","))
+	}
+	if runAsUser != nil {
+		command = append(command, "-uid", strconv.FormatInt(*runAsUser, 10))
+	}
+	if runAsGroup != nil {
+		command = append(command, "-gid", strconv.FormatInt(*runAsGroup, 10))
+	}
+	return command
+}
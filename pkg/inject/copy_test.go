@@ -0,0 +1,23 @@
+package inject
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zerok-ai/zerok-injector/pkg/config"
+)
+
+func TestGetCopyCommandOnlyCopiesTheSelectedFiles(t *testing.T) {
+	initContainerConfig := &config.InitContainerConfig{}
+	command := getCopyCommand(initContainerConfig, map[string]bool{"zk-otel-extension.jar": true, "opentelemetry-javaagent.jar": true}, int64Ptr(1000), nil)
+	expected := []string{copierPath, "-source", initImageAgentPath, "-target", copyTargetPath, "-files", "opentelemetry-javaagent.jar,zk-otel-extension.jar", "-uid", "1000"}
+	if !reflect.DeepEqual(command, expected) {
+		t.Fatalf("expected %q, got %q", expected, command)
+	}
+
+	command = getCopyCommand(initContainerConfig, nil, nil, int64Ptr(2000))
+	expected = []string{copierPath, "-source", initImageAgentPath, "-target", copyTargetPath, "-gid", "2000"}
+	if !reflect.DeepEqual(command, expected) {
+		t.Fatalf("expected %q, got %q", expected, command)
+	}
+}
@@ -0,0 +1,59 @@
+package inject
+
+import (
+	"fmt"
+
+	"github.com/zerok-ai/zerok-injector/pkg/config"
+	"github.com/zerok-ai/zerok-injector/pkg/zkclient"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// getDelivery picks how the agent files reach the pod. A pod which already got the init container from an
+// earlier pass keeps it, so that all its containers read the same files.
+func getDelivery(pod *corev1.Pod, names *injectionNames, injectorConfig *config.InjectorConfig) string {
+	if findInitContainer(pod, names) >= 0 {
+		return config.DeliveryInitContainer
+	}
+	if injectorConfig.AgentImage.Image == "" {
+		if injectorConfig.Delivery != config.DeliveryInitContainer {
+			fmt.Printf("No agent image configured, delivering the agent through the init container.\n")
+		}
+		return config.DeliveryInitContainer
+	}
+	switch injectorConfig.Delivery {
+	case config.DeliveryImageVolume:
+		return config.DeliveryImageVolume
+	case config.DeliveryAuto:
+		if zkclient.IsImageVolumeSupported(GetInjectorNamespace(), injectorConfig.AgentImage.GetImage()) {
+			return config.DeliveryImageVolume
+		}
+	}
+	return config.DeliveryInitContainer
+}
+
+// setImageVolume sets the agent volume to mount the agent image. The typed pod cannot hold an image volume
+// source, so the volume is written into the json of the mutated pod, replacing a volume of the same name.
+func setImageVolume(names *injectionNames, agentImage *config.AgentImageConfig) treeMutation {
+	return func(tree map[string]interface{}) error {
+		spec, ok := tree["spec"].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("pod has no spec")
+		}
+
+		image := map[string]interface{}{"reference": agentImage.GetImage()}
+		if agentImage.ImagePullPolicy != "" {
+			image["pullPolicy"] = string(agentImage.ImagePullPolicy)
+		}
+		volume := map[string]interface{}{"name": names.Volume, "image": image}
+
+		volumes, _ := spec["volumes"].([]interface{})
+		for i := range volumes {
+			if existing, ok := volumes[i].(map[string]interface{}); ok && existing["name"] == names.Volume {
+				volumes[i] = volume
+				return nil
+			}
+		}
+		spec["volumes"] = append(volumes, volume)
+		return nil
+	}
+}
@@ -0,0 +1,24 @@
+package inject
+
+import (
+	"testing"
+
+	"github.com/zerok-ai/zerok-injector/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestGetDeliveryNeedsAnAgentImage(t *testing.T) {
+	injectorConfig := config.Default()
+	if delivery := getDelivery(&corev1.Pod{}, &defaultInjectionNames, injectorConfig); delivery != config.DeliveryInitContainer {
+		t.Fatalf("expected the init container by default, got %v", delivery)
+	}
+
+	injectorConfig.Delivery = config.DeliveryImageVolume
+	if delivery := getDelivery(&corev1.Pod{}, &defaultInjectionNames, injectorConfig); delivery != config.DeliveryInitContainer {
+		t.Fatalf("expected the init container without an agent image, got %v", delivery)
+	}
+	injectorConfig.AgentImage.Image = "rajeevzerok/agent-bundle:1.0.0"
+	if delivery := getDelivery(&corev1.Pod{}, &defaultInjectionNames, injectorConfig); delivery != config.DeliveryImageVolume {
+		t.Fatalf("expected the image volume, got %v", delivery)
+	}
+}
@@ -0,0 +1,126 @@
+package inject
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/zerok-ai/zerok-injector/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	injectedAnnotation = "zerok.ai/injected"
+	// Where the agent files are in the init image.
+	initImageAgentPath = "/opt/zerok"
+)
+
+func isAnnotatedAsInjected(pod *corev1.Pod) bool {
+	return pod.Annotations[injectedAnnotation] == "true"
+}
+
+func markAsInjected(pod *corev1.Pod) {
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations[injectedAnnotation] = "true"
+}
+
+func findInitContainer(pod *corev1.Pod, names *injectionNames) int {
+	for i := range pod.Spec.InitContainers {
+		if pod.Spec.InitContainers[i].Name == names.InitContainer {
+			return i
+		}
+	}
+	return -1
+}
+
+func findVolume(pod *corev1.Pod, names *injectionNames) int {
+	for i := range pod.Spec.Volumes {
+		if pod.Spec.Volumes[i].Name == names.Volume {
+			return i
+		}
+	}
+	return -1
+}
+
+func hasAgentVolumeMount(container *corev1.Container, names *injectionNames) bool {
+	for _, volumeMount := range container.VolumeMounts {
+		if volumeMount.Name == names.Volume {
+			return true
+		}
+	}
+	return false
+}
+
+func isCommandWrapped(container *corev1.Container, names *injectionNames) bool {
+	for _, arg := range append(append([]string{}, container.Command...), container.Args...) {
+		if strings.Contains(arg, names.scriptPath()) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasRecordedBundle tells whether an earlier pass recorded the agent bundle of the container, which also covers
+// bundles of the catalog loading the agent through variables the built in profiles do not know.
+func hasRecordedBundle(pod *corev1.Pod, containerName string) bool {
+	value, ok := pod.Annotations[agentBundleAnnotation]
+	if !ok {
+		return false
+	}
+	recorded := map[string]json.RawMessage{}
+	if err := json.Unmarshal([]byte(value), &recorded); err != nil {
+		return false
+	}
+	_, ok = recorded[containerName]
+	return ok
+}
+
+func isContainerInjected(pod *corev1.Pod, container *corev1.Container, names *injectionNames) bool {
+	return isCommandWrapped(container, names) || hasAgentEnv(container, names.MountPath) || hasRecordedBundle(pod, container.Name)
+}
+
+func getInjectedContainerNames(pod *corev1.Pod, names *injectionNames) map[string]bool {
+	containerNames := map[string]bool{}
+	for i := range pod.Spec.Containers {
+		if isContainerInjected(pod, &pod.Spec.Containers[i], names) {
+			containerNames[pod.Spec.Containers[i].Name] = true
+		}
+	}
+	return containerNames
+}
+
+// isAgentInitContainer tells whether an init container was injected by us, from the image it runs or from the
+// copy command, which also covers init images pinned or mirrored under another name.
+func isAgentInitContainer(container *corev1.Container, initContainerConfig *config.InitContainerConfig) bool {
+	if container.Image == initContainerConfig.Image || container.Image == initContainerConfig.GetImage() {
+		return true
+	}
+	for _, arg := range container.Command {
+		if arg == copierPath || strings.Contains(arg, initImageAgentPath+"/.") {
+			return true
+		}
+	}
+	return false
+}
+
+// isPodInjected detects an injection done by an earlier pass, either through the marker annotation or
+// structurally for pods which were mutated before the annotation existed or had it stripped. An init container
+// and volume which merely share our names belong to the application and are not taken as an injection.
+func isPodInjected(pod *corev1.Pod) bool {
+	if isAnnotatedAsInjected(pod) {
+		return true
+	}
+	names := getInjectionNames(pod)
+	if index := findInitContainer(pod, names); index >= 0 && findVolume(pod, names) >= 0 {
+		if isAgentInitContainer(&pod.Spec.InitContainers[index], &config.ForNamespace(pod.Namespace).InitContainer) {
+			return true
+		}
+	}
+	for i := range pod.Spec.Containers {
+		if isContainerInjected(pod, &pod.Spec.Containers[i], names) {
+			return true
+		}
+	}
+	return false
+}
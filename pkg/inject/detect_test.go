@@ -0,0 +1,26 @@
+package inject
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestIsContainerInjectedFromRecordedBundle(t *testing.T) {
+	// A catalog bundle loading the agent through a variable none of the built in profiles sets.
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{agentBundleAnnotation: `{"app":"zerok-nodejs@1.0.0"}`}},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{
+			{Name: "app", Env: []corev1.EnvVar{{Name: "ZK_AGENT_PRELOAD", Value: "/opt/zerok/nodejs/register.js"}}},
+			{Name: "worker"},
+		}},
+	}
+	names := &defaultInjectionNames
+	if !isContainerInjected(pod, &pod.Spec.Containers[0], names) {
+		t.Fatal("expected the container with a recorded bundle to be injected")
+	}
+	if isContainerInjected(pod, &pod.Spec.Containers[1], names) {
+		t.Fatal("expected the container without a recorded bundle not to be injected")
+	}
+}
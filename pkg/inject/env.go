@@ -0,0 +1,161 @@
+package inject
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	javaToolOptionsEnv = "JAVA_TOOL_OPTIONS"
+	// Holds the original value of a merged variable which came from valueFrom.
+	originalEnvPrefix = "ZK_ORIGINAL_"
+)
+
+func findEnv(container *corev1.Container, name string) int {
+	for i := range container.Env {
+		if container.Env[i].Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// hasAgentEnv detects containers which already load the agent through one of the variables of the profiles.
+// Only the mount path is looked for, since the files referenced differ between versions of the agent bundles.
+func hasAgentEnv(container *corev1.Container, mountPath string) bool {
+	for _, profile := range profiles {
+		for _, rule := range append(append([]envRule{}, profile.Env...), profile.ArgvEnv...) {
+			index := findEnv(container, rule.name)
+			if index >= 0 && strings.Contains(container.Env[index].Value, mountPath+"/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// mergeEnv sets the variable, or with a separator puts the value in front of any value the container already
+// has. A value coming from valueFrom is moved to another variable and referenced from the new value, which
+// kubernetes expands when starting the container. Without a separator an existing value is never replaced.
+func mergeEnv(container *corev1.Container, name string, value string, separator string) {
+	index := findEnv(container, name)
+	if index < 0 {
+		container.Env = append(container.Env, corev1.EnvVar{Name: name, Value: value})
+		return
+	}
+	if separator == "" {
+		return
+	}
+
+	existing := container.Env[index]
+	if isOptionalEnvSource(existing.ValueFrom) {
+		// An unresolved reference is left as it is by kubernetes, so a missing source would leave
+		// "$(ZK_ORIGINAL_...)" in the value.
+		fmt.Printf("Not merging %v of container %v, its value comes from an optional source.\n", name, container.Name)
+		return
+	}
+	if existing.ValueFrom != nil {
+		originalName := originalEnvPrefix + name
+		original := corev1.EnvVar{Name: originalName, ValueFrom: existing.ValueFrom}
+		merged := corev1.EnvVar{Name: name, Value: value + separator + "$(" + originalName + ")"}
+		env := append([]corev1.EnvVar{}, container.Env[:index]...)
+		env = append(env, original, merged)
+		container.Env = append(env, container.Env[index+1:]...)
+		return
+	}
+
+	if strings.TrimSpace(existing.Value) == "" {
+		container.Env[index].Value = value
+		return
+	}
+	container.Env[index].Value = value + separator + existing.Value
+}
+
+func isOptionalEnvSource(source *corev1.EnvVarSource) bool {
+	if source == nil {
+		return false
+	}
+	if source.ConfigMapKeyRef != nil {
+		return source.ConfigMapKeyRef.Optional != nil && *source.ConfigMapKeyRef.Optional
+	}
+	if source.SecretKeyRef != nil {
+		return source.SecretKeyRef.Optional != nil && *source.SecretKeyRef.Optional
+	}
+	return false
+}
+
+// materializeEnvFrom copies the variables with the given names which the container only gets through envFrom
+// into its env, as references to the same keys. Kubernetes prefers env over envFrom, so without this an
+// injected variable would replace the value of the ConfigMap or Secret instead of being merged with it.
+func materializeEnvFrom(container *corev1.Container, names []string, getKeys func(source corev1.EnvFromSource) ([]string, error)) error {
+	wanted := map[string]bool{}
+	for _, name := range names {
+		if findEnv(container, name) < 0 {
+			wanted[name] = true
+		}
+	}
+	if len(wanted) == 0 || len(container.EnvFrom) == 0 {
+		return nil
+	}
+
+	materialized := map[string]corev1.EnvVar{}
+	for _, source := range container.EnvFrom {
+		keys, err := getKeys(source)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			name := source.Prefix + key
+			if !wanted[name] {
+				continue
+			}
+			// A key defined by several sources takes the value of the last one.
+			envVar := corev1.EnvVar{Name: name, ValueFrom: &corev1.EnvVarSource{}}
+			if source.ConfigMapRef != nil {
+				envVar.ValueFrom.ConfigMapKeyRef = &corev1.ConfigMapKeySelector{
+					LocalObjectReference: source.ConfigMapRef.LocalObjectReference,
+					Key:                  key,
+					Optional:             source.ConfigMapRef.Optional,
+				}
+			} else if source.SecretRef != nil {
+				envVar.ValueFrom.SecretKeyRef = &corev1.SecretKeySelector{
+					LocalObjectReference: source.SecretRef.LocalObjectReference,
+					Key:                  key,
+					Optional:             source.SecretRef.Optional,
+				}
+			} else {
+				continue
+			}
+			materialized[name] = envVar
+		}
+	}
+
+	materializedNames := make([]string, 0, len(materialized))
+	for name := range materialized {
+		materializedNames = append(materializedNames, name)
+	}
+	sort.Strings(materializedNames)
+	env := make([]corev1.EnvVar, 0, len(materializedNames))
+	for _, name := range materializedNames {
+		fmt.Printf("Copying %v of container %v out of its envFrom sources.\n", name, container.Name)
+		env = append(env, materialized[name])
+	}
+	// The envFrom variables are defined before the env ones, so they stay visible to every reference.
+	prependEnv(container, env)
+	return nil
+}
+
+// prependEnv puts the variables the container does not define yet in front of its environment, so that they
+// can be referenced from any other variable.
+func prependEnv(container *corev1.Container, env []corev1.EnvVar) {
+	missing := []corev1.EnvVar{}
+	for _, envVar := range env {
+		if findEnv(container, envVar.Name) < 0 {
+			missing = append(missing, envVar)
+		}
+	}
+	container.Env = append(missing, container.Env...)
+}
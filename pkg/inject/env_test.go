@@ -0,0 +1,90 @@
+package inject
+
+import (
+	"fmt"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestMergeEnvLeavesOptionalSourcesAlone(t *testing.T) {
+	optional := &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+		LocalObjectReference: corev1.LocalObjectReference{Name: "app"},
+		Key:                  "JAVA_TOOL_OPTIONS",
+		Optional:             boolPtr(true),
+	}}
+	container := &corev1.Container{Name: "app", Env: []corev1.EnvVar{{Name: javaToolOptionsEnv, ValueFrom: optional}}}
+	mergeEnv(container, javaToolOptionsEnv, "-javaagent:/opt/zerok/agent.jar", " ")
+	if len(container.Env) != 1 || container.Env[0].ValueFrom != optional {
+		t.Fatalf("expected the optional source to be left alone, got %+v", container.Env)
+	}
+
+	required := &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+		LocalObjectReference: corev1.LocalObjectReference{Name: "app"},
+		Key:                  "JAVA_TOOL_OPTIONS",
+	}}
+	container = &corev1.Container{Name: "app", Env: []corev1.EnvVar{{Name: javaToolOptionsEnv, ValueFrom: required}}}
+	mergeEnv(container, javaToolOptionsEnv, "-javaagent:/opt/zerok/agent.jar", " ")
+	if len(container.Env) != 2 || container.Env[0].Name != originalEnvPrefix+javaToolOptionsEnv || container.Env[1].Value != "-javaagent:/opt/zerok/agent.jar $(ZK_ORIGINAL_JAVA_TOOL_OPTIONS)" {
+		t.Fatalf("expected the required source to be referenced, got %+v", container.Env)
+	}
+}
+
+func TestMaterializeEnvFromMergesWithTheSource(t *testing.T) {
+	container := &corev1.Container{
+		Name: "app",
+		EnvFrom: []corev1.EnvFromSource{
+			{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "base"}}},
+			{Prefix: "APP_", SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "app"}, Optional: boolPtr(true)}},
+			{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "java"}}},
+		},
+		Env: []corev1.EnvVar{{Name: "OTEL_SERVICE_NAME", Value: "app"}},
+	}
+	keys := map[string][]string{
+		"base": {javaToolOptionsEnv, "OTEL_PROPAGATORS"},
+		"app":  {"OTEL_EXPORTER_OTLP_HEADERS"},
+		"java": {javaToolOptionsEnv},
+	}
+	err := materializeEnvFrom(container, []string{javaToolOptionsEnv, "APP_OTEL_EXPORTER_OTLP_HEADERS", "OTEL_SERVICE_NAME", "NODE_OPTIONS"}, func(source corev1.EnvFromSource) ([]string, error) {
+		if source.ConfigMapRef != nil {
+			return keys[source.ConfigMapRef.Name], nil
+		}
+		return keys[source.SecretRef.Name], nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(container.Env) != 3 {
+		t.Fatalf("expected two variables to be copied, got %+v", container.Env)
+	}
+	headers := container.Env[0]
+	if headers.Name != "APP_OTEL_EXPORTER_OTLP_HEADERS" || headers.ValueFrom.SecretKeyRef.Key != "OTEL_EXPORTER_OTLP_HEADERS" || !*headers.ValueFrom.SecretKeyRef.Optional {
+		t.Errorf("expected the optional secret key, got %+v", headers)
+	}
+	javaToolOptions := container.Env[1]
+	if javaToolOptions.Name != javaToolOptionsEnv || javaToolOptions.ValueFrom.ConfigMapKeyRef.Name != "java" {
+		t.Errorf("expected the key of the last source, got %+v", javaToolOptions)
+	}
+
+	mergeEnv(container, javaToolOptionsEnv, "-javaagent:/opt/zerok/agent.jar", " ")
+	if index := findEnv(container, javaToolOptionsEnv); container.Env[index].Value != "-javaagent:/opt/zerok/agent.jar $(ZK_ORIGINAL_JAVA_TOOL_OPTIONS)" {
+		t.Fatalf("expected the value of the config map to be kept, got %+v", container.Env)
+	}
+}
+
+func TestMaterializeEnvFromFailsWithoutTheKeys(t *testing.T) {
+	container := &corev1.Container{
+		Name:    "app",
+		EnvFrom: []corev1.EnvFromSource{{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "app"}}}},
+	}
+	err := materializeEnvFrom(container, []string{javaToolOptionsEnv}, func(source corev1.EnvFromSource) ([]string, error) {
+		return nil, fmt.Errorf("forbidden")
+	})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if err := materializeEnvFrom(&corev1.Container{Name: "app"}, []string{javaToolOptionsEnv}, nil); err != nil {
+		t.Fatalf("expected no lookup without envFrom, got %v", err)
+	}
+}
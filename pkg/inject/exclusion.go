@@ -0,0 +1,139 @@
+package inject
+
+import (
+	"os"
+	"path"
+
+	"github.com/zerok-ai/zerok-injector/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	injectorNamespaceEnv     = "ZK_INJECTOR_NAMESPACE"
+	defaultInjectorNamespace = "zk-injector"
+	injectorAppLabel         = "zk-injector"
+	mirrorPodAnnotation      = "kubernetes.io/config.mirror"
+	osLabel                  = "kubernetes.io/os"
+	windowsOS                = "windows"
+)
+
+// Namespaces of the control plane, never injected whatever their labels say.
+var deniedNamespaces = map[string]bool{
+	"kube-system":     true,
+	"kube-public":     true,
+	"kube-node-lease": true,
+}
+
+// GetInjectorNamespace returns the namespace the injector runs in, passed through the downward API.
+func GetInjectorNamespace() string {
+	if namespace := os.Getenv(injectorNamespaceEnv); namespace != "" {
+		return namespace
+	}
+	return defaultInjectorNamespace
+}
+
+// GetDeniedNamespaces returns the namespaces which are never injected, the injector namespace included.
+func GetDeniedNamespaces() []string {
+	namespaces := []string{"kube-system", "kube-public", "kube-node-lease"}
+	return append(namespaces, GetInjectorNamespace())
+}
+
+// getSkipReason checks the pod against the rules which protect the cluster and the injector from being
+// instrumented, and against the exclusions of the configuration. An empty reason means the pod may be injected.
+func getSkipReason(pod *corev1.Pod, exclusions *config.ExclusionConfig, workload *Workload) string {
+	if deniedNamespaces[pod.Namespace] {
+		return "it runs in the system namespace " + pod.Namespace
+	}
+	if pod.Namespace == GetInjectorNamespace() || pod.Labels["app"] == injectorAppLabel {
+		return "it is part of the injector"
+	}
+	if _, ok := pod.Annotations[mirrorPodAnnotation]; ok {
+		return "it is the mirror of a static pod"
+	}
+	if owner := metav1.GetControllerOf(pod); owner != nil && owner.Kind == "Node" {
+		return "it is the mirror of a static pod"
+	}
+	if isWindowsPod(pod) {
+		return "it is scheduled to windows nodes"
+	}
+	if pod.Spec.HostNetwork {
+		return "it uses the host network"
+	}
+
+	if pod.Spec.RuntimeClassName != nil && containsString(exclusions.RuntimeClasses, *pod.Spec.RuntimeClassName) {
+		return "its runtime class " + *pod.Spec.RuntimeClassName + " is excluded"
+	}
+	if owner := metav1.GetControllerOf(pod); owner != nil && containsString(exclusions.OwnerKinds, owner.Kind) {
+		return "it is owned by an excluded " + owner.Kind
+	}
+	if workload != nil && containsString(exclusions.OwnerKinds, workload.Kind) {
+		return "it is owned by an excluded " + workload.Kind
+	}
+	return ""
+}
+
+// isWindowsPod reads the target OS from spec.os, the node selector and the required node affinity. With node
+// affinity, the pod only counts as a windows pod when every term requires windows nodes.
+func isWindowsPod(pod *corev1.Pod) bool {
+	if pod.Spec.OS != nil && pod.Spec.OS.Name == corev1.Windows {
+		return true
+	}
+	if pod.Spec.NodeSelector[osLabel] == windowsOS {
+		return true
+	}
+
+	affinity := pod.Spec.Affinity
+	if affinity == nil || affinity.NodeAffinity == nil || affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return false
+	}
+	terms := affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	if len(terms) == 0 {
+		return false
+	}
+	for _, term := range terms {
+		if !requiresWindows(term) {
+			return false
+		}
+	}
+	return true
+}
+
+func requiresWindows(term corev1.NodeSelectorTerm) bool {
+	for _, expression := range term.MatchExpressions {
+		if expression.Key == osLabel && expression.Operator == corev1.NodeSelectorOpIn && len(expression.Values) == 1 && expression.Values[0] == windowsOS {
+			return true
+		}
+	}
+	return false
+}
+
+// filterExcludedImages drops the containers whose image matches one of the excluded glob patterns.
+func filterExcludedImages(pod *corev1.Pod, containerIndexes []int, patterns []string) []int {
+	indexes := []int{}
+	for _, i := range containerIndexes {
+		if isImageExcluded(pod.Spec.Containers[i].Image, patterns) {
+			continue
+		}
+		indexes = append(indexes, i)
+	}
+	return indexes
+}
+
+func isImageExcluded(image string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, err := path.Match(pattern, image); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package inject
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/zerok-ai/zerok-injector/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	hostIPEnv           = "ZK_HOST_IP"
+	otlpHeaderEnvPrefix = "ZK_OTLP_HEADER_"
+)
+
+var exporterEnvNames = []string{
+	"OTEL_EXPORTER_OTLP_ENDPOINT",
+	"OTEL_EXPORTER_OTLP_PROTOCOL",
+	"OTEL_EXPORTER_OTLP_HEADERS",
+	"OTEL_TRACES_SAMPLER",
+	"OTEL_TRACES_SAMPLER_ARG",
+	"OTEL_PROPAGATORS",
+}
+
+// addExporterEnv renders the exporter configuration into the standard OTEL_* variables. Variables the user
+// already set are never overwritten. Secret headers whose key hasKey cannot find are left out, a missing
+// secret would keep the container from starting.
+func addExporterEnv(container *corev1.Container, exporterConfig *config.ExporterConfig, hasKey func(secretName string, key string) (bool, error)) {
+	endpoint := exporterConfig.Endpoint
+	if exporterConfig.NodeLocal {
+		port := exporterConfig.NodeLocalPort
+		if port == 0 {
+			port = 4317
+			if strings.HasPrefix(exporterConfig.Protocol, "http") {
+				port = 4318
+			}
+		}
+		endpoint = "http://$(" + hostIPEnv + "):" + strconv.Itoa(port)
+	}
+
+	headers := []string{}
+	headerNames := make([]string, 0, len(exporterConfig.Headers))
+	for name := range exporterConfig.Headers {
+		headerNames = append(headerNames, name)
+	}
+	sort.Strings(headerNames)
+	for _, name := range headerNames {
+		headers = append(headers, name+"="+exporterConfig.Headers[name])
+	}
+	secretHeaderEnv := []corev1.EnvVar{}
+	for i, secretHeader := range exporterConfig.SecretHeaders {
+		found, err := hasKey(secretHeader.SecretName, secretHeader.Key)
+		if err != nil {
+			fmt.Printf("Leaving the %v header out for container %v: %v.\n", secretHeader.Name, container.Name, err)
+			continue
+		}
+		if !found {
+			fmt.Printf("Leaving the %v header out for container %v, the secret %v has no key %v.\n", secretHeader.Name, container.Name, secretHeader.SecretName, secretHeader.Key)
+			continue
+		}
+		envName := otlpHeaderEnvPrefix + strconv.Itoa(i)
+		secretHeaderEnv = append(secretHeaderEnv, corev1.EnvVar{
+			Name: envName,
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: secretHeader.SecretName},
+					Key:                  secretHeader.Key,
+				},
+			},
+		})
+		headers = append(headers, fmt.Sprintf("%v=$(%v)", secretHeader.Name, envName))
+	}
+
+	// The variables referenced from the OTEL_* ones are only added when those are set by the injector.
+	if exporterConfig.NodeLocal && findEnv(container, "OTEL_EXPORTER_OTLP_ENDPOINT") < 0 {
+		prependEnv(container, []corev1.EnvVar{getFieldRefEnv(hostIPEnv, "status.hostIP")})
+	}
+	if len(secretHeaderEnv) > 0 && findEnv(container, "OTEL_EXPORTER_OTLP_HEADERS") < 0 {
+		prependEnv(container, secretHeaderEnv)
+	}
+
+	for _, envVar := range []corev1.EnvVar{
+		{Name: "OTEL_EXPORTER_OTLP_ENDPOINT", Value: endpoint},
+		{Name: "OTEL_EXPORTER_OTLP_PROTOCOL", Value: exporterConfig.Protocol},
+		{Name: "OTEL_EXPORTER_OTLP_HEADERS", Value: strings.Join(headers, ",")},
+		{Name: "OTEL_TRACES_SAMPLER", Value: exporterConfig.Sampler},
+		{Name: "OTEL_TRACES_SAMPLER_ARG", Value: exporterConfig.SamplerArg},
+		{Name: "OTEL_PROPAGATORS", Value: strings.Join(exporterConfig.Propagators, ",")},
+	} {
+		if envVar.Value != "" {
+			mergeEnv(container, envVar.Name, envVar.Value, "")
+		}
+	}
+}
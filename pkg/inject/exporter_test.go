@@ -0,0 +1,35 @@
+package inject
+
+import (
+	"testing"
+
+	"github.com/zerok-ai/zerok-injector/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestAddExporterEnvLeavesMissingSecretHeadersOut(t *testing.T) {
+	exporterConfig := &config.ExporterConfig{
+		Endpoint: "http://collector:4317",
+		Headers:  map[string]string{"x-tenant": "payments"},
+		SecretHeaders: []config.SecretHeader{
+			{Name: "authorization", SecretName: "otlp-credentials", Key: "authorization"},
+			{Name: "x-api-key", SecretName: "missing", Key: "key"},
+		},
+	}
+	container := &corev1.Container{Name: "app"}
+	addExporterEnv(container, exporterConfig, func(secretName string, key string) (bool, error) {
+		return secretName == "otlp-credentials", nil
+	})
+
+	if index := findEnv(container, otlpHeaderEnvPrefix+"1"); index >= 0 {
+		t.Fatalf("expected no variable for the missing secret, got %+v", container.Env[index])
+	}
+	index := findEnv(container, otlpHeaderEnvPrefix+"0")
+	if index < 0 || container.Env[index].ValueFrom.SecretKeyRef.Name != "otlp-credentials" {
+		t.Fatalf("expected the secret header, got %+v", container.Env)
+	}
+	headers := container.Env[findEnv(container, "OTEL_EXPORTER_OTLP_HEADERS")].Value
+	if headers != "x-tenant=payments,authorization=$(ZK_OTLP_HEADER_0)" {
+		t.Fatalf("expected only the found secret header, got %v", headers)
+	}
+}
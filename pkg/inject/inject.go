@@ -4,11 +4,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"strconv"
-	"strings"
 	"time"
 
 	"github.com/docker/docker/api/types"
+	dockercontainer "github.com/docker/docker/api/types/container"
+	"github.com/zerok-ai/zerok-injector/pkg/config"
 	"github.com/zerok-ai/zerok-injector/pkg/zkclient"
 	v1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
@@ -16,6 +16,10 @@ import (
 )
 
 func GetEmptyResponse(admissionReview v1.AdmissionReview) ([]byte, error) {
+	return getEmptyResponseWithWarnings(admissionReview)
+}
+
+func getEmptyResponseWithWarnings(admissionReview v1.AdmissionReview, warnings ...string) ([]byte, error) {
 	ar := admissionReview.Request
 	if ar != nil {
 		admissionResponse := v1.AdmissionResponse{}
@@ -23,11 +27,12 @@ func GetEmptyResponse(admissionReview v1.AdmissionReview) ([]byte, error) {
 		admissionResponse.Allowed = true
 		patchType := v1.PatchTypeJSONPatch
 		admissionResponse.PatchType = &patchType
-		patches := make([]map[string]interface{}, 0)
+		patches := make([]patchOperation, 0)
 		admissionResponse.Patch, _ = json.Marshal(patches)
 		admissionResponse.Result = &metav1.Status{
 			Status: "Success",
 		}
+		admissionResponse.Warnings = warnings
 		admissionReview.Response = &admissionResponse
 		responseBody, err := json.Marshal(admissionReview)
 		if err != nil {
@@ -58,6 +63,11 @@ func Inject(body []byte) ([]byte, error) {
 			return nil, fmt.Errorf("unable unmarshal pod json object %v", err)
 		}
 
+		// The namespace is not always set on the pod object during CREATE.
+		if pod.Namespace == "" {
+			pod.Namespace = ar.Namespace
+		}
+
 		admissionResponse.UID = ar.UID
 
 		dt := time.Now()
@@ -67,11 +77,30 @@ func Inject(body []byte) ([]byte, error) {
 		patchType := v1.PatchTypeJSONPatch
 		admissionResponse.PatchType = &patchType
 
-		patches, err := getPatches(pod, string(ar.UID))
+		var patches []patchOperation
+		var err error
+
+		switch ar.Operation {
+		case v1.Create:
+			patches, err = getPatches(pod, ar.Object.Raw, string(ar.UID))
+		case v1.Update:
+			patches, err = getUpdatePatches(ar.OldObject.Raw, pod)
+		default:
+			fmt.Printf("Passing through %v request with uid %v.\n", ar.Operation, ar.UID)
+			return emptyResponse, nil
+		}
 		if err != nil {
 			fmt.Printf("Error caught while getting the patches %v.\n", err)
 			return emptyResponse, err
 		}
+
+		err = verifyPatch(ar.Object.Raw, patches)
+		if err != nil {
+			fmt.Printf("Error caught while verifying the patches %v, skipping the injection.\n", err)
+			fallbackResponse, _ := getEmptyResponseWithWarnings(admissionReview, fmt.Sprintf("zerok agent was not injected: %v", err))
+			return fallbackResponse, fmt.Errorf("error caught while verifying the patches %v", err)
+		}
+
 		admissionResponse.Patch, err = json.Marshal(patches)
 
 		fmt.Printf("The patches are %v\n", patches)
@@ -98,34 +127,105 @@ func Inject(body []byte) ([]byte, error) {
 	return responseBody, nil
 }
 
-func getPatches(pod *corev1.Pod, uid string) ([]map[string]interface{}, error) {
-	p := make([]map[string]interface{}, 0)
-	p = append(p, getInitContainerPatches(pod)...)
-	p = append(p, getVolumePatch()...)
-	containerPatches, err := getContainerPatches(pod, uid)
+func getPatches(pod *corev1.Pod, rawPod []byte, uid string) ([]patchOperation, error) {
+	if !isInjectionEnabled(pod) {
+		fmt.Printf("Injection is disabled for pod %v/%v.\n", pod.Namespace, pod.GenerateName+pod.Name)
+		return make([]patchOperation, 0), nil
+	}
+
+	injectorConfig := config.ForNamespace(pod.Namespace)
+
+	workload := resolveWorkload(pod)
+	if reason := getSkipReason(pod, &injectorConfig.Exclusions, workload); reason != "" {
+		fmt.Printf("Skipping pod %v/%v since %v.\n", pod.Namespace, pod.GenerateName+pod.Name, reason)
+		return make([]patchOperation, 0), nil
+	}
+
+	containerIndexes := filterExcludedImages(pod, getContainersToInject(pod, injectorConfig.MeshContainers), injectorConfig.Exclusions.Images)
+	if len(containerIndexes) == 0 {
+		fmt.Printf("No containers selected for injection in pod %v/%v.\n", pod.Namespace, pod.GenerateName+pod.Name)
+		return make([]patchOperation, 0), nil
+	}
+
+	// Webhooks reinvoked after another webhook changed the pod see their own earlier changes, so only the
+	// containers added since are instrumented.
+	if isPodInjected(pod) {
+		fmt.Printf("Pod %v/%v is already injected, only adding the missing parts.\n", pod.Namespace, pod.GenerateName+pod.Name)
+	}
+
+	names := allocateInjectionNames(pod)
+	mutatedPod := pod.DeepCopy()
+	agentFiles, copyAll := getCopiedFiles(pod, names)
+	injectedContainers, err := injectContainers(mutatedPod, containerIndexes, injectorConfig, names, agentFiles, workload, uid)
 	if err != nil {
-		return make([]map[string]interface{}, 0), err
+		return make([]patchOperation, 0), err
+	}
+	// The agent files are only delivered when at least one container ended up instrumented.
+	treeMutations := []treeMutation{}
+	if injectedContainers > 0 {
+		if getDelivery(pod, names, injectorConfig) == config.DeliveryImageVolume {
+			fmt.Printf("Mounting the agent image %v in pod %v/%v.\n", injectorConfig.AgentImage.GetImage(), pod.Namespace, pod.GenerateName+pod.Name)
+			treeMutations = append(treeMutations, setImageVolume(names, &injectorConfig.AgentImage))
+		} else {
+			injectedContainerNames := getInjectedContainerNames(mutatedPod, names)
+			securityContext := getInitContainerSecurityContext(pod, injectorConfig.InitContainer.SecurityContext, getPodSecurityLevel(pod.Namespace), injectedContainerNames)
+			if copyAll {
+				agentFiles = nil
+			}
+			runAsUser, runAsGroup := getAppUser(pod, injectedContainerNames)
+			command := getCopyCommand(&injectorConfig.InitContainer, agentFiles, runAsUser, getAgentFilesGroup(pod, runAsGroup))
+			injectInitContainer(mutatedPod, getNativeSidecars(rawPod), &injectorConfig.InitContainer, names, command, securityContext)
+			injectVolume(mutatedPod, &injectorConfig.InitContainer, names)
+		}
+		recordInjectionNames(mutatedPod, names)
+		recordWorkload(mutatedPod, workload)
+		markAsInjected(mutatedPod)
+	}
+	p, err := createPatch(pod, mutatedPod, treeMutations...)
+	if err != nil {
+		return make([]patchOperation, 0), err
 	}
-	p = append(p, containerPatches...)
 	fmt.Printf("The patches created are %v.\n", p)
 	return p, nil
 }
 
-func getPatchCmdForContainer(container *corev1.Container, authConfig *types.AuthConfig, uid string) ([]string, error) {
+// Pod specs are mostly immutable once created, so on UPDATE the injection is never redone. The only thing
+// touched is the marker annotation, which is restored when an update strips it from an injected pod.
+func getUpdatePatches(oldPodRaw []byte, pod *corev1.Pod) ([]patchOperation, error) {
+	oldPod := &corev1.Pod{}
+	if len(oldPodRaw) > 0 {
+		if err := json.Unmarshal(oldPodRaw, oldPod); err != nil {
+			return make([]patchOperation, 0), fmt.Errorf("unable unmarshal old pod json object %v", err)
+		}
+	}
+
+	if !isPodInjected(oldPod) || !isPodInjected(pod) || isAnnotatedAsInjected(pod) {
+		return make([]patchOperation, 0), nil
+	}
+
+	fmt.Printf("Restoring the injection marker on pod %v/%v.\n", pod.Namespace, pod.Name)
+	mutatedPod := pod.DeepCopy()
+	markAsInjected(mutatedPod)
+	return createPatch(pod, mutatedPod)
+}
+
+func getImageConfigForContainer(container *corev1.Container, authConfig *types.AuthConfig, namespace string, uid string) (*dockercontainer.Config, error) {
 	if container == nil {
 		fmt.Println("Container is nil.")
-		return []string{}, fmt.Errorf("container is nil")
+		return nil, fmt.Errorf("container is nil")
 	}
-	existingCmd, err := zkclient.GetCommandFromImage(container.Image, authConfig, uid)
+	imageConfig, err := zkclient.GetImageConfig(container.Image, authConfig, namespace, uid)
 	if err != nil {
-		fmt.Println("Error while getting patch command for image: ", container.Image)
-		return []string{}, fmt.Errorf("error while getting patch command for image: %v, erro %v", container.Image, err)
+		fmt.Println("Error while getting image config for image: ", container.Image)
+		return nil, fmt.Errorf("error while getting image config for image: %v, erro %v", container.Image, err)
 	}
-	fmt.Println("Exiting cmd for container ", container.Name, " is ", existingCmd)
-	return existingCmd, nil
+	fmt.Println("Existing entrypoint and cmd for container ", container.Name, " are ", imageConfig.Entrypoint, imageConfig.Cmd)
+	return imageConfig, nil
 }
 
-func getContainerPatches(pod *corev1.Pod, uid string) ([]map[string]interface{}, error) {
+// injectContainers instruments the selected containers which run a supported runtime, returning how many
+// containers of the pod are instrumented, counting the ones instrumented by an earlier pass.
+func injectContainers(pod *corev1.Pod, containerIndexes []int, injectorConfig *config.InjectorConfig, names *injectionNames, agentFiles map[string]bool, workload *Workload, uid string) (int, error) {
 
 	imagePullSecrets := &pod.Spec.ImagePullSecrets
 
@@ -135,110 +235,224 @@ func getContainerPatches(pod *corev1.Pod, uid string) ([]map[string]interface{},
 		secrets = append(secrets, imagePullSecret.Name)
 	}
 
-	p := make([]map[string]interface{}, 0)
+	injectedContainers := 0
+	runtimes := map[string]interface{}{}
+	injectedLanguages := map[string]Language{}
+	bundles := map[string]interface{}{}
 
-	containers := pod.Spec.Containers
+	for _, i := range containerIndexes {
 
-	for i := range containers {
+		container := &pod.Spec.Containers[i]
 
-		authConfig, err := zkclient.GetAuthDetailsFromSecret(secrets, pod.Namespace, pod.Spec.Containers[i].Image)
+		if isContainerInjected(pod, container, names) {
+			fmt.Printf("Container %v is already injected.\n", container.Name)
+			injectedContainers++
+			continue
+		}
 
-		if err != nil {
-			fmt.Printf("Error caught while getting auth config %v for container %v.\n", err, i)
-			return p, fmt.Errorf("error caught while getting auth config %v", err)
+		var imageConfig *dockercontainer.Config
+		getImageConfig := func() (*dockercontainer.Config, error) {
+			if imageConfig != nil {
+				return imageConfig, nil
+			}
 
-		}
+			authConfig, err := zkclient.GetAuthDetailsFromSecret(secrets, pod.Namespace, container.Image)
+
+			if err != nil {
+				fmt.Printf("Error caught while getting auth config %v for container %v.\n", err, i)
+				return nil, fmt.Errorf("error caught while getting auth config %v", err)
+
+			}
+
+			imageConfig, err = getImageConfigForContainer(container, authConfig, pod.Namespace, uid)
 
-		podCmd, err := getPatchCmdForContainer(&pod.Spec.Containers[i], authConfig, uid)
+			if err != nil {
+				fmt.Printf("Error caught while getting command %v for container %v.\n", err, i)
+				return nil, fmt.Errorf("error caught while getting command %v", err)
 
+			}
+			return imageConfig, nil
+		}
+
+		language, ok, err := getLanguage(pod, container, injectorConfig, getImageConfig)
 		if err != nil {
-			fmt.Printf("Error caught while getting command %v for container %v.\n", err, i)
-			return p, fmt.Errorf("error caught while getting command %v", err)
+			return injectedContainers, err
+		}
+		if !ok {
+			fmt.Printf("No supported runtime detected for container %v, skipping it.\n", container.Name)
+			runtimes[container.Name] = unknownRuntime
+			continue
+		}
+		runtimes[container.Name] = string(language)
 
+		available, err := isLanguageAvailable(language, injectorConfig)
+		if err != nil {
+			fmt.Printf("Error caught while looking up the agents of the init image: %v.\n", err)
+			return injectedContainers, err
+		}
+		if !available {
+			fmt.Printf("The init image ships no %v agent, skipping container %v.\n", language, container.Name)
+			continue
 		}
 
-		addCommand := map[string]interface{}{
-			"op":    "add",
-			"path":  "/spec/containers/" + strconv.Itoa(i) + "/command",
-			"value": []string{"/bin/sh"},
+		profile, bundle, err := selectProfile(pod, container.Name, language, injectorConfig)
+		if err != nil {
+			fmt.Printf("Error caught while getting the agent profile for container %v: %v.\n", container.Name, err)
+			return injectedContainers, err
+		}
+		if bundle != nil {
+			fmt.Printf("Selected the agent bundle %v for container %v.\n", bundle.String(), container.Name)
+			bundles[container.Name] = bundle.String()
+		}
+		if profile.Language == LanguageJava {
+			javaOptions, err := getJavaOptions(pod, container.Name, &injectorConfig.Java)
+			if err != nil {
+				fmt.Printf("Error caught while reading the java options of container %v: %v.\n", container.Name, err)
+				return injectedContainers, err
+			}
+			shippedFiles, err := getShippedFiles(profile, injectorConfig)
+			if err != nil {
+				fmt.Printf("Error caught while looking up the agent files of the init image: %v.\n", err)
+				return injectedContainers, err
+			}
+			profile = profile.withJavaOptions(javaOptions, shippedFiles)
 		}
 
-		p = append(p, addCommand)
+		// Only the profiles which rewrite the argv need the image, the others are attached through the environment.
+		argv := []string{}
+		if profile.needsCommand(injectorConfig.InjectionMode) {
+			imageConfig, err := getImageConfig()
+			if err != nil {
+				return injectedContainers, err
+			}
+			argv = resolveArgv(container, imageConfig.Entrypoint, imageConfig.Cmd)
+		}
 
-		addArgs := map[string]interface{}{
-			"op":    "add",
-			"path":  "/spec/containers/" + strconv.Itoa(i) + "/args",
-			"value": []string{"-c", "/opt/zerok/zerok-agent.sh " + strings.Join(podCmd, " ")},
+		envNames := append(append(profile.getEnvNames(), exporterEnvNames...), otelServiceNameEnv, otelResourceAttributesEnv)
+		err = materializeEnvFrom(container, envNames, func(source corev1.EnvFromSource) ([]string, error) {
+			return zkclient.GetEnvFromKeys(pod.Namespace, source)
+		})
+		if err != nil {
+			// Without the keys of the sources any injected variable could replace one of them.
+			fmt.Printf("Error caught while reading the envFrom sources of container %v, skipping it: %v.\n", container.Name, err)
+			continue
 		}
 
-		p = append(p, addArgs)
+		if !hasAgentVolumeMount(container, names) {
+			container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+				MountPath: names.MountPath,
+				Name:      names.Volume,
+				ReadOnly:  true,
+			})
+		}
 
-		addVolumeMount := map[string]interface{}{
-			"op":   "add",
-			"path": "/spec/containers/" + strconv.Itoa(i) + "/volumeMounts/-",
-			"value": corev1.VolumeMount{
-				MountPath: "/opt/zerok",
-				Name:      "zerok-init",
-			},
+		usesAgentScript := profile.apply(container, injectorConfig.InjectionMode, argv, names.MountPath)
+		for _, file := range profile.Files {
+			if file != agentScript || usesAgentScript {
+				agentFiles[file] = true
+			}
 		}
+		addResourceAttributes(pod, container, workload)
+		addExporterEnv(container, &injectorConfig.Exporter, func(secretName string, key string) (bool, error) {
+			return zkclient.HasSecretKey(pod.Namespace, secretName, key)
+		})
+		injectedLanguages[container.Name] = language
+		injectedContainers++
 
-		p = append(p, addVolumeMount)
+	}
 
+	// The runtime of every container looked at and the bundle of every instrumented one are kept, on top of
+	// what earlier passes recorded.
+	if err := mergeJSONAnnotation(pod, runtimesAnnotation, runtimes); err != nil {
+		return injectedContainers, err
+	}
+	if err := mergeJSONAnnotation(pod, agentBundleAnnotation, bundles); err != nil {
+		return injectedContainers, err
 	}
 
-	return p, nil
-}
+	// Only containers instrumented in this pass get the overhead, so that a second pass never adds it twice.
+	if err := applyOverhead(pod, injectedLanguages, injectorConfig); err != nil {
+		fmt.Printf("Error caught while adding the agent overhead %v.\n", err)
+		return injectedContainers, err
+	}
 
-func getVolumePatch() []map[string]interface{} {
-	p := make([]map[string]interface{}, 0)
+	return injectedContainers, nil
+}
 
-	addVolume := map[string]interface{}{
-		"op":   "add",
-		"path": "/spec/volumes/-",
-		"value": corev1.Volume{
-			Name: "zerok-init",
-			VolumeSource: corev1.VolumeSource{
-				EmptyDir: &corev1.EmptyDirVolumeSource{},
-			},
-		},
+// getLanguage reads the language of a container from its annotations, else detects it when enabled, else
+// falls back to the default language. Containers whose runtime could not be detected are not instrumented.
+func getLanguage(pod *corev1.Pod, container *corev1.Container, injectorConfig *config.InjectorConfig, getImageConfig func() (*dockercontainer.Config, error)) (Language, bool, error) {
+	if language, ok := getContainerLanguage(pod, container.Name); ok {
+		return language, true, nil
+	}
+	if !injectorConfig.DetectRuntime {
+		return Language(injectorConfig.DefaultLanguage), true, nil
 	}
 
-	p = append(p, addVolume)
+	if injectorConfig.InjectionMode == config.InjectionModeJavaToolOptions {
+		// This mode never resolves images, so only the container spec is looked at.
+		language, ok := detectSpecRuntime(container)
+		if ok {
+			fmt.Printf("Detected runtime %v for container %v from its spec.\n", language, container.Name)
+		}
+		return language, ok, nil
+	}
 
-	return p
+	imageConfig, err := getImageConfig()
+	if err != nil {
+		return "", false, err
+	}
+	language, ok := detectRuntime(imageConfig, resolveArgv(container, imageConfig.Entrypoint, imageConfig.Cmd))
+	if ok {
+		fmt.Printf("Detected runtime %v for container %v.\n", language, container.Name)
+	}
+	return language, ok, nil
 }
 
-func getInitContainerPatches(pod *corev1.Pod) []map[string]interface{} {
-	p := make([]map[string]interface{}, 0)
-
-	if pod.Spec.InitContainers == nil {
-		initInitialize := map[string]interface{}{
-			"op":    "add",
-			"path":  "/spec/initContainers",
-			"value": []corev1.Container{},
-		}
+func injectVolume(pod *corev1.Pod, initContainerConfig *config.InitContainerConfig, names *injectionNames) {
+	volume := corev1.Volume{
+		Name: names.Volume,
+		VolumeSource: corev1.VolumeSource{
+			EmptyDir: &corev1.EmptyDirVolumeSource{
+				Medium:    initContainerConfig.VolumeMedium,
+				SizeLimit: initContainerConfig.SizeLimit,
+			},
+		},
+	}
 
-		p = append(p, initInitialize)
+	if index := findVolume(pod, names); index >= 0 {
+		pod.Spec.Volumes[index] = volume
+		return
 	}
+	pod.Spec.Volumes = append(pod.Spec.Volumes, volume)
+}
 
-	addInitContainer := map[string]interface{}{
-		"op":   "add",
-		"path": "/spec/initContainers/-",
-		"value": &corev1.Container{
-			Name:            "zerok-init",
-			Command:         []string{"cp", "-r", "/opt/zerok/.", "/opt/temp"},
-			Image:           "rajeevzerok/init-container:latest",
-			ImagePullPolicy: corev1.PullAlways,
-			VolumeMounts: []corev1.VolumeMount{
-				{
-					MountPath: "/opt/temp",
-					Name:      "zerok-init",
-				},
+func injectInitContainer(pod *corev1.Pod, nativeSidecars map[string]bool, initContainerConfig *config.InitContainerConfig, names *injectionNames, command []string, securityContext *corev1.SecurityContext) {
+	initContainer := corev1.Container{
+		Name:            names.InitContainer,
+		Command:         command,
+		Image:           initContainerConfig.GetImage(),
+		ImagePullPolicy: initContainerConfig.ImagePullPolicy,
+		Resources:       *initContainerConfig.Resources.DeepCopy(),
+		SecurityContext: securityContext,
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				MountPath: copyTargetPath,
+				Name:      names.Volume,
 			},
 		},
 	}
 
-	p = append(p, addInitContainer)
+	// An init container left by an earlier pass is replaced instead of being added twice, and moved if it is
+	// not where the placement rules want it.
+	initContainers := make([]corev1.Container, 0, len(pod.Spec.InitContainers)+1)
+	for _, existing := range pod.Spec.InitContainers {
+		if existing.Name != names.InitContainer {
+			initContainers = append(initContainers, existing)
+		}
+	}
 
-	return p
+	index := getInitContainerIndex(initContainers, nativeSidecars, initContainerConfig)
+	initContainers = append(initContainers[:index], append([]corev1.Container{initContainer}, initContainers[index:]...)...)
+	pod.Spec.InitContainers = initContainers
 }
@@ -0,0 +1,155 @@
+package inject
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/zerok-ai/zerok-injector/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	javaOptionsAnnotation                  = "zerok.ai/java-options"
+	javaExtensionsAnnotation               = "zerok.ai/java-extensions"
+	javaDisabledInstrumentationsAnnotation = "zerok.ai/java-disabled-instrumentations"
+	javaExtensionsOption                   = "-Dotel.javaagent.extensions="
+)
+
+var (
+	// Values are kept free of shell syntax, since the options are also inserted into "sh -c" scripts.
+	javaOptionPattern          = regexp.MustCompile(`^-D[A-Za-z0-9_.\-]+(=[A-Za-z0-9_.,:/@%+=\-]*)?$`)
+	javaExtensionPattern       = regexp.MustCompile(`^[A-Za-z0-9_./\-]+\.jar$`)
+	javaInstrumentationPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.\-]*$`)
+)
+
+// javaOptions are added to the options the java profile hands to the JVM.
+type javaOptions struct {
+	options    []string
+	extensions []string
+}
+
+// getJavaOptions combines the java settings of the configuration with the ones of the pod annotations, where
+// the zerok.ai/java-*.<container> annotations replace the ones of the pod. Every value is validated, since it
+// ends up in the command line or environment of the container.
+func getJavaOptions(pod *corev1.Pod, containerName string, javaConfig *config.JavaConfig) (*javaOptions, error) {
+	options := append([]string{}, javaConfig.Options...)
+	if value, ok := getContainerAnnotation(pod, javaOptionsAnnotation, containerName); ok {
+		options = append(options, strings.Fields(value)...)
+	}
+	extensions := append([]string{}, javaConfig.Extensions...)
+	if value, ok := getContainerAnnotation(pod, javaExtensionsAnnotation, containerName); ok {
+		extensions = append(extensions, parseList(value)...)
+	}
+	instrumentations := append([]string{}, javaConfig.DisabledInstrumentations...)
+	if value, ok := getContainerAnnotation(pod, javaDisabledInstrumentationsAnnotation, containerName); ok {
+		instrumentations = append(instrumentations, parseList(value)...)
+	}
+
+	// Values set both in the configuration and the annotations are only passed once.
+	seen := map[string]bool{}
+	javaOptions := &javaOptions{}
+	for _, option := range options {
+		if !javaOptionPattern.MatchString(option) || strings.HasPrefix(option, javaExtensionsOption) {
+			return nil, fmt.Errorf("invalid java option %q, only -Dname=value system properties are allowed", option)
+		}
+		if !seen[option] {
+			seen[option] = true
+			javaOptions.options = append(javaOptions.options, option)
+		}
+	}
+	for _, instrumentation := range instrumentations {
+		if !javaInstrumentationPattern.MatchString(instrumentation) {
+			return nil, fmt.Errorf("invalid java instrumentation name %q", instrumentation)
+		}
+		option := "-Dotel.instrumentation." + instrumentation + ".enabled=false"
+		if !seen[option] {
+			seen[option] = true
+			javaOptions.options = append(javaOptions.options, option)
+		}
+	}
+	for _, extension := range extensions {
+		if !javaExtensionPattern.MatchString(extension) || strings.Contains(extension, "..") {
+			return nil, fmt.Errorf("invalid java extension %q, expected the path of a jar", extension)
+		}
+		extension = path.Clean(extension)
+		if !seen[extension] {
+			seen[extension] = true
+			javaOptions.extensions = append(javaOptions.extensions, extension)
+		}
+	}
+	return javaOptions, nil
+}
+
+func getContainerAnnotation(pod *corev1.Pod, annotation string, containerName string) (string, bool) {
+	if value, ok := pod.Annotations[annotation+"."+containerName]; ok {
+		return value, true
+	}
+	value, ok := pod.Annotations[annotation]
+	return value, ok
+}
+
+func parseList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// withJavaOptions returns a copy of the java profile with the options added to both the argv rule and the
+// JAVA_TOOL_OPTIONS rule, so they apply whatever the injection mode. Relative extensions are files of the
+// init image and are copied along with the agent, so the ones missing from shippedFiles are dropped, since
+// the copy would fail on them. Absolute ones are expected in the image of the container.
+func (p *Profile) withJavaOptions(javaOptions *javaOptions, shippedFiles map[string]bool) *Profile {
+	if len(javaOptions.options) == 0 && len(javaOptions.extensions) == 0 {
+		return p
+	}
+
+	extensions := []string{}
+	profile := *p
+	profile.Files = append([]string{}, p.Files...)
+	for _, extension := range javaOptions.extensions {
+		if path.IsAbs(extension) {
+			extensions = append(extensions, extension)
+			continue
+		}
+		if !shippedFiles[extension] {
+			fmt.Printf("Dropping the java extension %v, the init image does not ship it.\n", extension)
+			continue
+		}
+		extensions = append(extensions, agentPathPlaceholder+"/"+extension)
+		profile.Files = append(profile.Files, extension)
+	}
+
+	addOptions := func(options []string) []string {
+		added := make([]string, 0, len(options)+len(javaOptions.options)+1)
+		extended := false
+		for _, option := range options {
+			if strings.HasPrefix(option, javaExtensionsOption) && len(extensions) > 0 {
+				option += "," + strings.Join(extensions, ",")
+				extended = true
+			}
+			added = append(added, option)
+		}
+		if !extended && len(extensions) > 0 {
+			added = append(added, javaExtensionsOption+strings.Join(extensions, ","))
+		}
+		return append(added, javaOptions.options...)
+	}
+
+	if p.Argv != nil {
+		profile.Argv = &argvRule{binaries: p.Argv.binaries, options: addOptions(p.Argv.options)}
+	}
+	profile.ArgvEnv = make([]envRule, 0, len(p.ArgvEnv))
+	for _, rule := range p.ArgvEnv {
+		if rule.name == javaToolOptionsEnv {
+			rule.value = strings.Join(addOptions(strings.Fields(rule.value)), " ")
+		}
+		profile.ArgvEnv = append(profile.ArgvEnv, rule)
+	}
+	return &profile
+}
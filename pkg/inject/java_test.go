@@ -0,0 +1,131 @@
+package inject
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zerok-ai/zerok-injector/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetJavaOptions(t *testing.T) {
+	javaConfig := &config.JavaConfig{
+		Options:                  []string{"-Dotel.metrics.exporter=none"},
+		Extensions:               []string{"extensions/zk-sampler.jar"},
+		DisabledInstrumentations: []string{"jdbc"},
+	}
+	for _, test := range []struct {
+		name               string
+		annotations        map[string]string
+		expectedOptions    []string
+		expectedExtensions []string
+		valid              bool
+	}{
+		{
+			name:               "configuration only",
+			expectedOptions:    []string{"-Dotel.metrics.exporter=none", "-Dotel.instrumentation.jdbc.enabled=false"},
+			expectedExtensions: []string{"extensions/zk-sampler.jar"},
+			valid:              true,
+		},
+		{
+			name: "duplicates",
+			annotations: map[string]string{
+				javaOptionsAnnotation:                  "-Dotel.metrics.exporter=none -Dfoo=bar -Dfoo=bar",
+				javaExtensionsAnnotation:               "./extensions/zk-sampler.jar, /app/ext.jar, /app/ext.jar",
+				javaDisabledInstrumentationsAnnotation: "jdbc",
+			},
+			expectedOptions:    []string{"-Dotel.metrics.exporter=none", "-Dfoo=bar", "-Dotel.instrumentation.jdbc.enabled=false"},
+			expectedExtensions: []string{"extensions/zk-sampler.jar", "/app/ext.jar"},
+			valid:              true,
+		},
+		{
+			name:               "container annotation replaces the pod one",
+			annotations:        map[string]string{javaOptionsAnnotation: "-Dpod=true", javaOptionsAnnotation + ".app": "-Dcontainer=true"},
+			expectedOptions:    []string{"-Dotel.metrics.exporter=none", "-Dcontainer=true", "-Dotel.instrumentation.jdbc.enabled=false"},
+			expectedExtensions: []string{"extensions/zk-sampler.jar"},
+			valid:              true,
+		},
+		{name: "not a system property", annotations: map[string]string{javaOptionsAnnotation: "-Xmx1g"}},
+		{name: "shell syntax", annotations: map[string]string{javaOptionsAnnotation: "-Dfoo=$(id)"}},
+		{name: "extensions option", annotations: map[string]string{javaOptionsAnnotation: "-Dotel.javaagent.extensions=/tmp/x.jar"}},
+		{name: "parent extension path", annotations: map[string]string{javaExtensionsAnnotation: "../ext.jar"}},
+		{name: "extension without jar", annotations: map[string]string{javaExtensionsAnnotation: "extensions/ext"}},
+		{name: "invalid instrumentation", annotations: map[string]string{javaDisabledInstrumentationsAnnotation: "JDBC;"}},
+	} {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: test.annotations}}
+		javaOptions, err := getJavaOptions(pod, "app", javaConfig)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("%v: expected an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(javaOptions.options, test.expectedOptions) || !reflect.DeepEqual(javaOptions.extensions, test.expectedExtensions) {
+			t.Errorf("%v: got options %q and extensions %q", test.name, javaOptions.options, javaOptions.extensions)
+		}
+	}
+}
+
+func TestWithJavaOptions(t *testing.T) {
+	profile, err := GetProfile(LanguageJava)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shippedFiles, err := getShippedFiles(profile, &config.InjectorConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	shippedFiles["extensions/zk-sampler.jar"] = true
+
+	for _, test := range []struct {
+		name            string
+		javaOptions     *javaOptions
+		expectedFiles   []string
+		expectedOptions string
+	}{
+		{
+			name:            "nothing to add",
+			javaOptions:     &javaOptions{},
+			expectedFiles:   profile.Files,
+			expectedOptions: "-javaagent:{{agent}}/opentelemetry-javaagent.jar -Dotel.javaagent.extensions={{agent}}/zk-otel-extension.jar",
+		},
+		{
+			name:            "absolute extension",
+			javaOptions:     &javaOptions{extensions: []string{"/app/ext.jar"}},
+			expectedFiles:   profile.Files,
+			expectedOptions: "-javaagent:{{agent}}/opentelemetry-javaagent.jar -Dotel.javaagent.extensions={{agent}}/zk-otel-extension.jar,/app/ext.jar",
+		},
+		{
+			name:            "shipped relative extension",
+			javaOptions:     &javaOptions{options: []string{"-Dfoo=bar"}, extensions: []string{"extensions/zk-sampler.jar"}},
+			expectedFiles:   append(append([]string{}, profile.Files...), "extensions/zk-sampler.jar"),
+			expectedOptions: "-javaagent:{{agent}}/opentelemetry-javaagent.jar -Dotel.javaagent.extensions={{agent}}/zk-otel-extension.jar,{{agent}}/extensions/zk-sampler.jar -Dfoo=bar",
+		},
+		{
+			name:            "unknown relative extension",
+			javaOptions:     &javaOptions{extensions: []string{"extensions/missing.jar", "/app/ext.jar"}},
+			expectedFiles:   profile.Files,
+			expectedOptions: "-javaagent:{{agent}}/opentelemetry-javaagent.jar -Dotel.javaagent.extensions={{agent}}/zk-otel-extension.jar,/app/ext.jar",
+		},
+	} {
+		withOptions := profile.withJavaOptions(test.javaOptions, shippedFiles)
+		if !reflect.DeepEqual(withOptions.Files, test.expectedFiles) {
+			t.Errorf("%v: expected files %q, got %q", test.name, test.expectedFiles, withOptions.Files)
+		}
+		if value := withOptions.ArgvEnv[0].value; value != test.expectedOptions {
+			t.Errorf("%v: expected %v %q, got %q", test.name, javaToolOptionsEnv, test.expectedOptions, value)
+		}
+		if argvOptions := strings.Join(withOptions.Argv.options, " "); argvOptions != test.expectedOptions {
+			t.Errorf("%v: expected the argv options %q, got %q", test.name, test.expectedOptions, argvOptions)
+		}
+	}
+	if len(profile.Files) != 3 {
+		t.Fatalf("expected the built in profile to be left alone, got %q", profile.Files)
+	}
+}
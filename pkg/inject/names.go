@@ -0,0 +1,120 @@
+package inject
+
+import (
+	"encoding/json"
+	"path"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const injectionNamesAnnotation = "zerok.ai/injection-names"
+
+// injectionNames are the names the injected init container and volume use in one pod, and the path the agent
+// files are mounted at in the application containers.
+type injectionNames struct {
+	InitContainer string `json:"initContainer"`
+	Volume        string `json:"volume"`
+	MountPath     string `json:"mountPath"`
+}
+
+var defaultInjectionNames = injectionNames{
+	InitContainer: "zerok-init",
+	Volume:        "zerok-init",
+	MountPath:     "/opt/zerok",
+}
+
+func (n *injectionNames) scriptPath() string {
+	return n.MountPath + "/" + agentScript
+}
+
+// getInjectionNames returns the names recorded by an earlier pass. Pods injected before the names were
+// recorded always used the default ones.
+func getInjectionNames(pod *corev1.Pod) *injectionNames {
+	names := defaultInjectionNames
+	if value, ok := pod.Annotations[injectionNamesAnnotation]; ok {
+		recorded := injectionNames{}
+		if err := json.Unmarshal([]byte(value), &recorded); err == nil && recorded.InitContainer != "" && recorded.Volume != "" && recorded.MountPath != "" {
+			return &recorded
+		}
+	}
+	return &names
+}
+
+// allocateInjectionNames keeps the names of an earlier pass, so that a partially injected pod is completed
+// instead of being injected twice. Otherwise the default names are used unless the pod already uses them, in
+// which case the first free alternative with a numeric suffix is picked.
+func allocateInjectionNames(pod *corev1.Pod) *injectionNames {
+	if _, ok := pod.Annotations[injectionNamesAnnotation]; ok || isPodInjected(pod) {
+		names := getInjectionNames(pod)
+		// The mount path is only fixed once a container mounts the agent volume.
+		for i := range pod.Spec.Containers {
+			if hasAgentVolumeMount(&pod.Spec.Containers[i], names) {
+				return names
+			}
+		}
+		names.MountPath = getFreeMountPath(pod)
+		return names
+	}
+
+	containerNames := map[string]bool{}
+	for _, container := range pod.Spec.InitContainers {
+		containerNames[container.Name] = true
+	}
+	for _, container := range pod.Spec.Containers {
+		containerNames[container.Name] = true
+	}
+	for _, container := range pod.Spec.EphemeralContainers {
+		containerNames[container.Name] = true
+	}
+
+	volumeNames := map[string]bool{}
+	for _, volume := range pod.Spec.Volumes {
+		volumeNames[volume.Name] = true
+	}
+
+	return &injectionNames{
+		InitContainer: getFreeName(defaultInjectionNames.InitContainer, func(name string) bool { return containerNames[name] }),
+		Volume:        getFreeName(defaultInjectionNames.Volume, func(name string) bool { return volumeNames[name] }),
+		MountPath:     getFreeMountPath(pod),
+	}
+}
+
+func getFreeMountPath(pod *corev1.Pod) string {
+	mountPaths := []string{}
+	for _, container := range pod.Spec.Containers {
+		for _, volumeMount := range container.VolumeMounts {
+			mountPaths = append(mountPaths, path.Clean(volumeMount.MountPath))
+		}
+	}
+
+	return getFreeName(defaultInjectionNames.MountPath, func(mountPath string) bool {
+		// A mount of the application inside the agent directory would have to be created in a read only volume.
+		for _, existing := range mountPaths {
+			if existing == mountPath || strings.HasPrefix(existing, mountPath+"/") {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func getFreeName(name string, isTaken func(string) bool) string {
+	candidate := name
+	for i := 1; isTaken(candidate); i++ {
+		candidate = name + "-" + strconv.Itoa(i)
+	}
+	return candidate
+}
+
+func recordInjectionNames(pod *corev1.Pod, names *injectionNames) {
+	value, err := json.Marshal(names)
+	if err != nil {
+		return
+	}
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations[injectionNamesAnnotation] = string(value)
+}
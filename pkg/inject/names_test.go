@@ -0,0 +1,43 @@
+package inject
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func getPodWithInitContainer(initContainer corev1.Container) *corev1.Pod {
+	return &corev1.Pod{
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{initContainer},
+			Containers:     []corev1.Container{{Name: "app", Image: "app"}},
+			Volumes:        []corev1.Volume{{Name: "zerok-init"}},
+		},
+	}
+}
+
+func TestAllocateInjectionNamesAvoidsApplicationContainers(t *testing.T) {
+	pod := getPodWithInitContainer(corev1.Container{Name: "zerok-init", Image: "busybox", Command: []string{"sh", "-c", "true"}})
+	if isPodInjected(pod) {
+		t.Fatal("expected a pod with an application init container named zerok-init not to be injected")
+	}
+	names := allocateInjectionNames(pod)
+	if names.InitContainer != "zerok-init-1" || names.Volume != "zerok-init-1" {
+		t.Fatalf("expected fresh names, got %+v", names)
+	}
+}
+
+func TestAllocateInjectionNamesReusesLegacyInjection(t *testing.T) {
+	for _, command := range [][]string{
+		{"cp", "-r", "/opt/zerok/.", "/opt/temp"},
+		{copierPath, "-source", "/opt/zerok", "-target", "/opt/temp"},
+	} {
+		pod := getPodWithInitContainer(corev1.Container{Name: "zerok-init", Image: "registry.local/init:1", Command: command})
+		if !isPodInjected(pod) {
+			t.Fatalf("expected the pod with the init command %v to be injected", command)
+		}
+		if names := allocateInjectionNames(pod); names.InitContainer != "zerok-init" || names.Volume != "zerok-init" {
+			t.Fatalf("expected the default names to be reused, got %+v", names)
+		}
+	}
+}
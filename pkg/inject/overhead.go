@@ -0,0 +1,113 @@
+package inject
+
+import (
+	"fmt"
+
+	"github.com/zerok-ai/zerok-injector/pkg/config"
+	"github.com/zerok-ai/zerok-injector/pkg/zkclient"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const originalResourcesAnnotation = "zerok.ai/original-resources"
+
+// addOverhead raises the requests and limits of an instrumented container by the overhead policy of its
+// language, keeping the limits within the maximums of the namespace LimitRanges. It returns the resources
+// the container had before, or nil when nothing changed.
+func addOverhead(container *corev1.Container, policy config.OverheadPolicy, maximums corev1.ResourceList) *corev1.ResourceRequirements {
+	original := container.Resources.DeepCopy()
+	changed := false
+
+	for _, resourceOverhead := range []struct {
+		name    corev1.ResourceName
+		amount  *resource.Quantity
+		percent int64
+	}{
+		{corev1.ResourceMemory, policy.Memory, policy.MemoryPercent},
+		{corev1.ResourceCPU, policy.CPU, policy.CPUPercent},
+	} {
+		if resourceOverhead.amount == nil && resourceOverhead.percent == 0 {
+			continue
+		}
+
+		// Unset requests and limits are left alone, the container is not bounded by them anyway.
+		limit, hasLimit := container.Resources.Limits[resourceOverhead.name]
+		if hasLimit {
+			raised := raiseQuantity(resourceOverhead.name, limit, resourceOverhead.amount, resourceOverhead.percent)
+			if max, ok := maximums[resourceOverhead.name]; ok && raised.Cmp(max) > 0 {
+				raised = max
+			}
+			if raised.Cmp(limit) != 0 {
+				container.Resources.Limits[resourceOverhead.name] = raised
+				changed = true
+			}
+			limit = raised
+		}
+
+		if request, ok := container.Resources.Requests[resourceOverhead.name]; ok {
+			raised := raiseQuantity(resourceOverhead.name, request, resourceOverhead.amount, resourceOverhead.percent)
+			if hasLimit && raised.Cmp(limit) > 0 {
+				raised = limit
+			}
+			if raised.Cmp(request) != 0 {
+				container.Resources.Requests[resourceOverhead.name] = raised
+				changed = true
+			}
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+	return original
+}
+
+func raiseQuantity(name corev1.ResourceName, quantity resource.Quantity, amount *resource.Quantity, percent int64) resource.Quantity {
+	raised := quantity.DeepCopy()
+	if percent != 0 {
+		// Memory is raised in whole bytes, cpu in millicores.
+		if name == corev1.ResourceMemory {
+			raised.Add(*resource.NewQuantity(quantity.Value()*percent/100, quantity.Format))
+		} else {
+			raised.Add(*resource.NewMilliQuantity(quantity.MilliValue()*percent/100, quantity.Format))
+		}
+	}
+	if amount != nil {
+		raised.Add(*amount)
+	}
+	return raised
+}
+
+// applyOverhead adds the overhead to the instrumented containers and records the original resources of the
+// changed ones in the zerok.ai/original-resources annotation, so that the change can be audited and reverted.
+func applyOverhead(pod *corev1.Pod, containerLanguages map[string]Language, injectorConfig *config.InjectorConfig) error {
+	var maximums corev1.ResourceList
+	originals := map[string]interface{}{}
+
+	for i := range pod.Spec.Containers {
+		container := &pod.Spec.Containers[i]
+		language, ok := containerLanguages[container.Name]
+		if !ok {
+			continue
+		}
+		policy, ok := injectorConfig.Overhead[string(language)]
+		if !ok {
+			continue
+		}
+
+		if maximums == nil {
+			var err error
+			maximums, err = zkclient.GetContainerLimitRangeMaximums(pod.Namespace)
+			if err != nil {
+				return err
+			}
+		}
+
+		if original := addOverhead(container, policy, maximums); original != nil {
+			fmt.Printf("Added the %v agent overhead to the resources of container %v.\n", language, container.Name)
+			originals[container.Name] = *original
+		}
+	}
+
+	return mergeJSONAnnotation(pod, originalResourcesAnnotation, originals)
+}
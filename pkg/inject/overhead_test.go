@@ -0,0 +1,64 @@
+package inject
+
+import (
+	"testing"
+
+	"github.com/zerok-ai/zerok-injector/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func getContainerWithResources(requests corev1.ResourceList, limits corev1.ResourceList) *corev1.Container {
+	return &corev1.Container{Name: "app", Resources: corev1.ResourceRequirements{Requests: requests, Limits: limits}}
+}
+
+func expectQuantity(t *testing.T, name string, quantity resource.Quantity, expected string) {
+	t.Helper()
+	if quantity.Cmp(resource.MustParse(expected)) != 0 {
+		t.Errorf("expected %v to be %v, got %v", name, expected, quantity.String())
+	}
+}
+
+func TestAddOverheadRaisesRequestsAndLimits(t *testing.T) {
+	memory := resource.MustParse("64Mi")
+	policy := config.OverheadPolicy{Memory: &memory, MemoryPercent: 10, CPUPercent: 5}
+	container := getContainerWithResources(
+		corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("500Mi"), corev1.ResourceCPU: resource.MustParse("200m")},
+		corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1000Mi"), corev1.ResourceCPU: resource.MustParse("1")},
+	)
+
+	original := addOverhead(container, policy, nil)
+	if original == nil {
+		t.Fatal("expected the resources to change")
+	}
+	expectQuantity(t, "the memory request", container.Resources.Requests[corev1.ResourceMemory], "614Mi")
+	expectQuantity(t, "the memory limit", container.Resources.Limits[corev1.ResourceMemory], "1164Mi")
+	expectQuantity(t, "the cpu request", container.Resources.Requests[corev1.ResourceCPU], "210m")
+	expectQuantity(t, "the cpu limit", container.Resources.Limits[corev1.ResourceCPU], "1050m")
+	expectQuantity(t, "the original memory request", original.Requests[corev1.ResourceMemory], "500Mi")
+}
+
+func TestAddOverheadKeepsWithinTheLimitRange(t *testing.T) {
+	memory := resource.MustParse("256Mi")
+	policy := config.OverheadPolicy{Memory: &memory}
+	container := getContainerWithResources(
+		corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("400Mi")},
+		corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("450Mi")},
+	)
+
+	addOverhead(container, policy, corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("512Mi")})
+	expectQuantity(t, "the memory limit", container.Resources.Limits[corev1.ResourceMemory], "512Mi")
+	// The request never exceeds the capped limit.
+	expectQuantity(t, "the memory request", container.Resources.Requests[corev1.ResourceMemory], "512Mi")
+}
+
+func TestAddOverheadLeavesUnsetResourcesAlone(t *testing.T) {
+	memory := resource.MustParse("64Mi")
+	container := getContainerWithResources(nil, nil)
+	if original := addOverhead(container, config.OverheadPolicy{Memory: &memory, CPUPercent: 10}, nil); original != nil {
+		t.Fatalf("expected no change, got %+v", container.Resources)
+	}
+	if container.Resources.Requests != nil || container.Resources.Limits != nil {
+		t.Fatalf("expected no requests and limits, got %+v", container.Resources)
+	}
+}
@@ -0,0 +1,155 @@
+package inject
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+type patchOperation struct {
+	Op    string
+	Path  string
+	Value interface{}
+}
+
+func (p patchOperation) MarshalJSON() ([]byte, error) {
+	if p.Op == "remove" {
+		return json.Marshal(map[string]interface{}{"op": p.Op, "path": p.Path})
+	}
+	return json.Marshal(map[string]interface{}{"op": p.Op, "path": p.Path, "value": p.Value})
+}
+
+// treeMutation changes the json of the mutated pod, for fields newer than the pod types of the client.
+type treeMutation func(tree map[string]interface{}) error
+
+// createPatch generates the RFC 6902 patch which turns the original pod into the mutated one. Mutations are
+// always done on a typed copy of the pod and the patch is derived by diffing the json of both.
+func createPatch(original *corev1.Pod, mutated *corev1.Pod, treeMutations ...treeMutation) ([]patchOperation, error) {
+	originalTree, err := toJSONTree(original)
+	if err != nil {
+		return nil, fmt.Errorf("error caught while converting original pod to json %v", err)
+	}
+	mutatedTree, err := toJSONTree(mutated)
+	if err != nil {
+		return nil, fmt.Errorf("error caught while converting mutated pod to json %v", err)
+	}
+	for _, mutate := range treeMutations {
+		if err := mutate(mutatedTree.(map[string]interface{})); err != nil {
+			return nil, fmt.Errorf("error caught while mutating the pod json %v", err)
+		}
+	}
+	return diffValues("", originalTree, mutatedTree), nil
+}
+
+func toJSONTree(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var tree interface{}
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+func escapeJSONPointer(token string) string {
+	token = strings.ReplaceAll(token, "~", "~0")
+	return strings.ReplaceAll(token, "/", "~1")
+}
+
+func diffValues(path string, original interface{}, mutated interface{}) []patchOperation {
+	originalMap, originalIsMap := original.(map[string]interface{})
+	mutatedMap, mutatedIsMap := mutated.(map[string]interface{})
+	if originalIsMap && mutatedIsMap {
+		return diffObjects(path, originalMap, mutatedMap)
+	}
+
+	originalArray, originalIsArray := original.([]interface{})
+	mutatedArray, mutatedIsArray := mutated.([]interface{})
+	if originalIsArray && mutatedIsArray {
+		return diffArrays(path, originalArray, mutatedArray)
+	}
+
+	if reflect.DeepEqual(original, mutated) {
+		return []patchOperation{}
+	}
+	return []patchOperation{{Op: "replace", Path: path, Value: mutated}}
+}
+
+func diffObjects(path string, original map[string]interface{}, mutated map[string]interface{}) []patchOperation {
+	p := make([]patchOperation, 0)
+
+	keys := make([]string, 0, len(original)+len(mutated))
+	for key := range original {
+		keys = append(keys, key)
+	}
+	for key := range mutated {
+		if _, ok := original[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		keyPath := path + "/" + escapeJSONPointer(key)
+		originalValue, inOriginal := original[key]
+		mutatedValue, inMutated := mutated[key]
+		switch {
+		case inOriginal && !inMutated:
+			p = append(p, patchOperation{Op: "remove", Path: keyPath})
+		case !inOriginal && inMutated:
+			p = append(p, patchOperation{Op: "add", Path: keyPath, Value: mutatedValue})
+		default:
+			p = append(p, diffValues(keyPath, originalValue, mutatedValue)...)
+		}
+	}
+	return p
+}
+
+// diffArrays walks the longest common subsequence of both arrays, so that insertions and removals in the
+// middle of an array do not turn into a replacement of every following element.
+func diffArrays(path string, original []interface{}, mutated []interface{}) []patchOperation {
+	n, m := len(original), len(mutated)
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if reflect.DeepEqual(original[i], mutated[j]) {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	p := make([]patchOperation, 0)
+	i, j, index := 0, 0, 0
+	for i < n || j < m {
+		elementPath := path + "/" + strconv.Itoa(index)
+		switch {
+		case i < n && j < m && reflect.DeepEqual(original[i], mutated[j]):
+			i, j, index = i+1, j+1, index+1
+		case i < n && j < m && lcs[i+1][j+1] == lcs[i][j]:
+			// Neither element is part of the common subsequence, so change the element in place.
+			p = append(p, diffValues(elementPath, original[i], mutated[j])...)
+			i, j, index = i+1, j+1, index+1
+		case j >= m || (i < n && lcs[i+1][j] >= lcs[i][j+1]):
+			p = append(p, patchOperation{Op: "remove", Path: elementPath})
+			i++
+		default:
+			p = append(p, patchOperation{Op: "add", Path: elementPath, Value: mutated[j]})
+			j, index = j+1, index+1
+		}
+	}
+	return p
+}
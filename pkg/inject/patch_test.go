@@ -0,0 +1,215 @@
+package inject
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Keys include the characters which have to be escaped in json pointers.
+var randomKeys = []string{"a", "b", "name", "volumes", "a/b", "~", "~1", "/", "", "x~/y"}
+
+func randomValue(r *rand.Rand, depth int) interface{} {
+	kind := r.Intn(7)
+	if depth <= 0 {
+		kind = r.Intn(4)
+	}
+	switch kind {
+	case 0:
+		return nil
+	case 1:
+		return r.Intn(2) == 0
+	case 2:
+		return float64(r.Intn(5))
+	case 3:
+		return randomKeys[r.Intn(len(randomKeys))]
+	case 4, 5:
+		return randomObject(r, depth-1)
+	default:
+		return randomArray(r, depth-1)
+	}
+}
+
+func randomObject(r *rand.Rand, depth int) map[string]interface{} {
+	object := map[string]interface{}{}
+	for i := r.Intn(5); i > 0; i-- {
+		object[randomKeys[r.Intn(len(randomKeys))]] = randomValue(r, depth)
+	}
+	return object
+}
+
+func randomArray(r *rand.Rand, depth int) []interface{} {
+	array := []interface{}{}
+	for i := r.Intn(6); i > 0; i-- {
+		array = append(array, randomValue(r, depth))
+	}
+	return array
+}
+
+// mutateValue derives a value from the original, the way the injector changes pods: elements and keys are
+// added, removed and changed while most of the value stays the same.
+func mutateValue(r *rand.Rand, value interface{}, depth int) interface{} {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		mutated := map[string]interface{}{}
+		for key, element := range typed {
+			switch r.Intn(5) {
+			case 0:
+			case 1:
+				mutated[key] = mutateValue(r, element, depth-1)
+			default:
+				mutated[key] = element
+			}
+		}
+		if r.Intn(2) == 0 {
+			mutated[randomKeys[r.Intn(len(randomKeys))]] = randomValue(r, depth)
+		}
+		return mutated
+	case []interface{}:
+		mutated := []interface{}{}
+		for _, element := range typed {
+			switch r.Intn(6) {
+			case 0:
+			case 1:
+				mutated = append(mutated, mutateValue(r, element, depth-1))
+			case 2:
+				mutated = append(mutated, randomValue(r, depth), element)
+			default:
+				mutated = append(mutated, element)
+			}
+		}
+		if r.Intn(3) == 0 {
+			mutated = append(mutated, randomValue(r, depth))
+		}
+		return mutated
+	default:
+		if r.Intn(3) == 0 {
+			return randomValue(r, depth)
+		}
+		return value
+	}
+}
+
+func applyPatch(t *testing.T, original interface{}, patches []patchOperation) []byte {
+	t.Helper()
+	originalBytes, err := json.Marshal(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patchBytes, err := json.Marshal(patches)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patch, err := jsonpatch.DecodePatch(patchBytes)
+	if err != nil {
+		t.Fatalf("invalid patch %s: %v", patchBytes, err)
+	}
+	patched, err := patch.Apply(originalBytes)
+	if err != nil {
+		t.Fatalf("patch %s does not apply to %s: %v", patchBytes, originalBytes, err)
+	}
+	return patched
+}
+
+// equalJSON compares documents by value, jsonpatch.Equal does not handle null values.
+func equalJSON(t *testing.T, a []byte, b []byte) bool {
+	t.Helper()
+	var aTree, bTree interface{}
+	if err := json.Unmarshal(a, &aTree); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, &bTree); err != nil {
+		t.Fatal(err)
+	}
+	return reflect.DeepEqual(aTree, bTree)
+}
+
+func TestDiffValuesTurnsOriginalIntoTarget(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 20000; i++ {
+		original := randomObject(r, 4)
+		var target map[string]interface{}
+		if i%2 == 0 {
+			target = mutateValue(r, original, 4).(map[string]interface{})
+		} else {
+			target = randomObject(r, 4)
+		}
+
+		patched := applyPatch(t, original, diffValues("", original, target))
+		targetBytes, _ := json.Marshal(target)
+		if !equalJSON(t, patched, targetBytes) {
+			t.Fatalf("case %v: patched %s, expected %s", i, patched, targetBytes)
+		}
+	}
+}
+
+func TestDiffValuesOfEqualValuesIsEmpty(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	for i := 0; i < 1000; i++ {
+		original := randomObject(r, 4)
+		if patches := diffValues("", original, original); len(patches) != 0 {
+			t.Fatalf("case %v: expected no patches, got %v", i, patches)
+		}
+	}
+}
+
+func TestDiffArraysInsertsInTheMiddle(t *testing.T) {
+	original := []interface{}{"a", "b", "c"}
+	mutated := []interface{}{"a", "x", "b", "c"}
+	patches := diffValues("/list", original, mutated)
+	expected := []patchOperation{{Op: "add", Path: "/list/1", Value: "x"}}
+	if fmt.Sprint(patches) != fmt.Sprint(expected) {
+		t.Fatalf("expected %v, got %v", expected, patches)
+	}
+}
+
+func TestCreatePatchAddsToMissingLists(t *testing.T) {
+	original := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "app"},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "app", Image: "app"}},
+		},
+	}
+	mutated := original.DeepCopy()
+	mutated.Annotations = map[string]string{"zerok.ai/a~b/c": "true"}
+	mutated.Spec.Volumes = []corev1.Volume{{Name: defaultInjectionNames.Volume}}
+	mutated.Spec.InitContainers = []corev1.Container{{Name: defaultInjectionNames.InitContainer, Image: "init"}}
+	mutated.Spec.Containers[0].VolumeMounts = []corev1.VolumeMount{{Name: defaultInjectionNames.Volume, MountPath: defaultInjectionNames.MountPath}}
+
+	patches, err := createPatch(original, mutated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patched := applyPatch(t, original, patches)
+	mutatedBytes, _ := json.Marshal(mutated)
+	if !equalJSON(t, patched, mutatedBytes) {
+		t.Fatalf("patched %s, expected %s", patched, mutatedBytes)
+	}
+
+	originalBytes, _ := json.Marshal(original)
+	if err := verifyPatch(originalBytes, patches); err != nil {
+		t.Fatalf("expected the patch to verify: %v", err)
+	}
+}
+
+func TestCreatePatchAppliesTreeMutations(t *testing.T) {
+	original := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "app"}}}}
+	patches, err := createPatch(original, original.DeepCopy(), func(tree map[string]interface{}) error {
+		tree["spec"].(map[string]interface{})["volumes"] = []interface{}{
+			map[string]interface{}{"name": "agent", "image": map[string]interface{}{"reference": "agent"}},
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(patches) != 1 || patches[0].Op != "add" || patches[0].Path != "/spec/volumes" {
+		t.Fatalf("expected the volumes to be added, got %v", patches)
+	}
+}
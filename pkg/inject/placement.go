@@ -0,0 +1,60 @@
+package inject
+
+import (
+	"encoding/json"
+
+	"github.com/zerok-ai/zerok-injector/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Native sidecars are init containers with restartPolicy Always (kubernetes 1.28+). The typed pod of the
+// client library in use does not carry the field, so it is read from the raw pod json.
+func getNativeSidecars(rawPod []byte) map[string]bool {
+	podSpec := struct {
+		Spec struct {
+			InitContainers []struct {
+				Name          string `json:"name"`
+				RestartPolicy string `json:"restartPolicy"`
+			} `json:"initContainers"`
+		} `json:"spec"`
+	}{}
+
+	sidecars := map[string]bool{}
+	if err := json.Unmarshal(rawPod, &podSpec); err != nil {
+		return sidecars
+	}
+	for _, initContainer := range podSpec.Spec.InitContainers {
+		if initContainer.RestartPolicy == "Always" {
+			sidecars[initContainer.Name] = true
+		}
+	}
+	return sidecars
+}
+
+// getInitContainerIndex decides where the agent init container goes among the other init containers, which
+// must not include the agent init container itself.
+//
+// With placement "first" it is put before every init container which may run application code, but after the
+// leading service mesh init containers and mesh native sidecars, so that the network setup of the mesh still
+// comes first. With placement "last" it goes after every regular init container, but ahead of the native
+// sidecars following them, since those keep running next to the application containers.
+func getInitContainerIndex(initContainers []corev1.Container, nativeSidecars map[string]bool, initContainerConfig *config.InitContainerConfig) int {
+	if initContainerConfig.Placement == config.PlacementLast {
+		index := len(initContainers)
+		for index > 0 && nativeSidecars[initContainers[index-1].Name] {
+			index--
+		}
+		return index
+	}
+
+	meshContainers := map[string]bool{}
+	for _, name := range initContainerConfig.MeshInitContainers {
+		meshContainers[name] = true
+	}
+
+	index := 0
+	for index < len(initContainers) && meshContainers[initContainers[index].Name] {
+		index++
+	}
+	return index
+}
@@ -0,0 +1,247 @@
+package inject
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/zerok-ai/zerok-injector/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+)
+
+type Language string
+
+const (
+	LanguageJava   Language = "java"
+	LanguageNodeJS Language = "nodejs"
+	LanguagePython Language = "python"
+	LanguageDotNet Language = "dotnet"
+)
+
+const (
+	languageAnnotation = "zerok.ai/language"
+	// Replaced with the path the agent files are mounted at in the application container.
+	agentPathPlaceholder = "{{agent}}"
+)
+
+// envRule sets one environment variable of the application container. With a separator the value is merged
+// in front of an existing value, without one an existing value is left alone.
+type envRule struct {
+	name      string
+	value     string
+	separator string
+}
+
+// argvRule inserts options right after the runtime binary in the argv of the container.
+type argvRule struct {
+	binaries []string
+	options  []string
+}
+
+// Profile describes how the agent of one language is attached to a container: the files it needs from the
+// init image, the environment to set and how the argv of the container is rewritten.
+type Profile struct {
+	Language Language
+	Files    []string
+	Env      []envRule
+	Argv     *argvRule
+	// Used instead of the argv rule when the injection mode leaves the command of the container alone.
+	ArgvEnv []envRule
+}
+
+var profiles = map[Language]*Profile{
+	LanguageJava: {
+		Language: LanguageJava,
+		Files:    []string{"zerok-agent.sh", "opentelemetry-javaagent.jar", "zk-otel-extension.jar"},
+		Argv: &argvRule{
+			binaries: []string{"java"},
+			options: []string{
+				"-javaagent:" + agentPathPlaceholder + "/opentelemetry-javaagent.jar",
+				"-Dotel.javaagent.extensions=" + agentPathPlaceholder + "/zk-otel-extension.jar",
+			},
+		},
+		ArgvEnv: []envRule{
+			{
+				name:      javaToolOptionsEnv,
+				value:     "-javaagent:" + agentPathPlaceholder + "/opentelemetry-javaagent.jar -Dotel.javaagent.extensions=" + agentPathPlaceholder + "/zk-otel-extension.jar",
+				separator: " ",
+			},
+		},
+	},
+	LanguageNodeJS: {
+		Language: LanguageNodeJS,
+		Files:    []string{"nodejs"},
+		Env: []envRule{
+			{name: "NODE_OPTIONS", value: "--require " + agentPathPlaceholder + "/nodejs/autoinstrumentation.js", separator: " "},
+		},
+	},
+	LanguagePython: {
+		Language: LanguagePython,
+		Files:    []string{"python"},
+		Env: []envRule{
+			// The auto instrumentation directory holds the sitecustomize module python loads on start.
+			{name: "PYTHONPATH", value: agentPathPlaceholder + "/python/opentelemetry/instrumentation/auto_instrumentation:" + agentPathPlaceholder + "/python", separator: ":"},
+		},
+	},
+	LanguageDotNet: {
+		Language: LanguageDotNet,
+		Files:    []string{"dotnet"},
+		Env: []envRule{
+			{name: "CORECLR_ENABLE_PROFILING", value: "1"},
+			{name: "CORECLR_PROFILER", value: "{918728DD-259F-4A6A-AC2B-B85E1B658318}"},
+			{name: "CORECLR_PROFILER_PATH", value: agentPathPlaceholder + "/dotnet/linux-x64/OpenTelemetry.AutoInstrumentation.Native.so"},
+			{name: "DOTNET_STARTUP_HOOKS", value: agentPathPlaceholder + "/dotnet/net/OpenTelemetry.AutoInstrumentation.StartupHook.dll", separator: ":"},
+			{name: "DOTNET_ADDITIONAL_DEPS", value: agentPathPlaceholder + "/dotnet/AdditionalDeps", separator: ":"},
+			{name: "DOTNET_SHARED_STORE", value: agentPathPlaceholder + "/dotnet/store", separator: ":"},
+			{name: "OTEL_DOTNET_AUTO_HOME", value: agentPathPlaceholder + "/dotnet"},
+		},
+	},
+}
+
+func GetProfile(language Language) (*Profile, error) {
+	profile, ok := profiles[Language(strings.ToLower(string(language)))]
+	if !ok {
+		return nil, fmt.Errorf("no agent profile for language %v", language)
+	}
+	return profile, nil
+}
+
+// getContainerLanguage reads the language of a container from the zerok.ai/language.<container> annotation,
+// then from the zerok.ai/language annotation of the pod.
+func getContainerLanguage(pod *corev1.Pod, containerName string) (Language, bool) {
+	if value, ok := pod.Annotations[languageAnnotation+"."+containerName]; ok {
+		return Language(strings.TrimSpace(value)), true
+	}
+	if value, ok := pod.Annotations[languageAnnotation]; ok {
+		return Language(strings.TrimSpace(value)), true
+	}
+	return "", false
+}
+
+// needsCommand tells whether the argv rule applies, which also needs the agent script to start the container.
+func (p *Profile) needsCommand(injectionMode string) bool {
+	if p.Argv == nil || injectionMode == config.InjectionModeJavaToolOptions {
+		return false
+	}
+	for _, file := range p.Files {
+		if file == agentScript {
+			return true
+		}
+	}
+	fmt.Printf("The %v agent ships no %v, falling back to the environment.\n", p.Language, agentScript)
+	return false
+}
+
+// getEnvNames returns the variables the profile may set.
+func (p *Profile) getEnvNames() []string {
+	names := []string{}
+	for _, rule := range append(append([]envRule{}, p.Env...), p.ArgvEnv...) {
+		names = append(names, rule.name)
+	}
+	return names
+}
+
+// apply attaches the agent to the container, returning whether the container is started through the agent
+// script.
+func (p *Profile) apply(container *corev1.Container, injectionMode string, argv []string, mountPath string) bool {
+	for _, rule := range p.Env {
+		mergeEnv(container, rule.name, renderAgentPath(rule.value, mountPath), rule.separator)
+	}
+
+	if p.needsCommand(injectionMode) {
+		options := make([]string, 0, len(p.Argv.options))
+		for _, option := range p.Argv.options {
+			options = append(options, renderAgentPath(option, mountPath))
+		}
+
+		rewrittenArgv, matched := p.Argv.rewrite(argv, options)
+		if matched {
+			// The "--" tells the agent script that the argv is already prepared and only has to be executed.
+			container.Command = []string{mountPath + "/" + agentScript, "--"}
+			container.Args = rewrittenArgv
+			return true
+		}
+		fmt.Printf("No %v binary found in the argv %v of container %v, falling back to the environment.\n", p.Language, argv, container.Name)
+	}
+
+	for _, rule := range p.ArgvEnv {
+		mergeEnv(container, rule.name, renderAgentPath(rule.value, mountPath), rule.separator)
+	}
+	return false
+}
+
+func renderAgentPath(value string, mountPath string) string {
+	return strings.ReplaceAll(value, agentPathPlaceholder, mountPath)
+}
+
+func (r *argvRule) isRuntimeBinary(word string) bool {
+	for _, binary := range r.binaries {
+		if path.Base(word) == binary {
+			return true
+		}
+	}
+	return false
+}
+
+// rewrite inserts the options after every runtime binary in the argv. Arguments of a shell "-c" are scripts,
+// so the options are inserted after the matching words inside them as well.
+func (r *argvRule) rewrite(argv []string, options []string) ([]string, bool) {
+	rewritten := make([]string, 0, len(argv)+len(options))
+	matched := false
+	for i, arg := range argv {
+		if i > 0 && argv[i-1] == "-c" && strings.ContainsAny(arg, " \t\n") {
+			script, scriptMatched := r.rewriteScript(arg, options)
+			rewritten = append(rewritten, script)
+			matched = matched || scriptMatched
+			continue
+		}
+		rewritten = append(rewritten, arg)
+		if r.isRuntimeBinary(arg) {
+			rewritten = append(rewritten, options...)
+			matched = true
+		}
+	}
+	return rewritten, matched
+}
+
+func (r *argvRule) rewriteScript(script string, options []string) (string, bool) {
+	var rewritten strings.Builder
+	matched := false
+	wordStart := -1
+	for i := 0; i <= len(script); i++ {
+		if i < len(script) && !strings.ContainsRune(" \t\n;&|()", rune(script[i])) {
+			if wordStart < 0 {
+				wordStart = i
+			}
+			continue
+		}
+		if wordStart >= 0 {
+			word := script[wordStart:i]
+			rewritten.WriteString(word)
+			if r.isRuntimeBinary(word) {
+				rewritten.WriteString(" " + strings.Join(options, " "))
+				matched = true
+			}
+			wordStart = -1
+		}
+		if i < len(script) {
+			rewritten.WriteByte(script[i])
+		}
+	}
+	return rewritten.String(), matched
+}
+
+// resolveArgv works out the argv the container runs with, following the rules kubernetes uses to combine the
+// command and args of the container with the entrypoint and cmd of the image.
+func resolveArgv(container *corev1.Container, entrypoint []string, cmd []string) []string {
+	argv := []string{}
+	if len(container.Command) > 0 {
+		argv = append(argv, container.Command...)
+		return append(argv, container.Args...)
+	}
+	argv = append(argv, entrypoint...)
+	if len(container.Args) > 0 {
+		return append(argv, container.Args...)
+	}
+	return append(argv, cmd...)
+}
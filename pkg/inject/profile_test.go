@@ -0,0 +1,157 @@
+package inject
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zerok-ai/zerok-injector/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestArgvRuleRewrite(t *testing.T) {
+	rule := &argvRule{binaries: []string{"java"}}
+	options := []string{"-javaagent:/opt/zerok/agent.jar"}
+
+	for _, test := range []struct {
+		argv     []string
+		expected []string
+		matched  bool
+	}{
+		{
+			argv:     []string{"java", "-jar", "app.jar"},
+			expected: []string{"java", "-javaagent:/opt/zerok/agent.jar", "-jar", "app.jar"},
+			matched:  true,
+		},
+		{
+			argv:     []string{"/usr/bin/java", "-jar", "app.jar"},
+			expected: []string{"/usr/bin/java", "-javaagent:/opt/zerok/agent.jar", "-jar", "app.jar"},
+			matched:  true,
+		},
+		{
+			argv:     []string{"sh", "-c", "exec java -jar app.jar"},
+			expected: []string{"sh", "-c", "exec java -javaagent:/opt/zerok/agent.jar -jar app.jar"},
+			matched:  true,
+		},
+		{
+			argv:     []string{"sh", "-c", "cd /app && java -jar app.jar; echo done"},
+			expected: []string{"sh", "-c", "cd /app && java -javaagent:/opt/zerok/agent.jar -jar app.jar; echo done"},
+			matched:  true,
+		},
+		{
+			// Words which merely end in the binary name are left alone.
+			argv:     []string{"sh", "-c", "run-java.sh --port 8080"},
+			expected: []string{"sh", "-c", "run-java.sh --port 8080"},
+			matched:  false,
+		},
+		{
+			argv:     []string{"node", "server.js"},
+			expected: []string{"node", "server.js"},
+			matched:  false,
+		},
+	} {
+		rewritten, matched := rule.rewrite(test.argv, options)
+		if matched != test.matched || !reflect.DeepEqual(rewritten, test.expected) {
+			t.Errorf("rewrite(%q) = %q, %v, expected %q, %v", test.argv, rewritten, matched, test.expected, test.matched)
+		}
+	}
+}
+
+func TestResolveArgv(t *testing.T) {
+	entrypoint := []string{"/entrypoint.sh"}
+	cmd := []string{"java", "-jar", "app.jar"}
+
+	for _, test := range []struct {
+		container corev1.Container
+		expected  []string
+	}{
+		{corev1.Container{}, []string{"/entrypoint.sh", "java", "-jar", "app.jar"}},
+		{corev1.Container{Args: []string{"--debug"}}, []string{"/entrypoint.sh", "--debug"}},
+		{corev1.Container{Command: []string{"java"}}, []string{"java"}},
+		{corev1.Container{Command: []string{"java"}, Args: []string{"-jar", "other.jar"}}, []string{"java", "-jar", "other.jar"}},
+	} {
+		if argv := resolveArgv(&test.container, entrypoint, cmd); !reflect.DeepEqual(argv, test.expected) {
+			t.Errorf("resolveArgv(%+v) = %q, expected %q", test.container, argv, test.expected)
+		}
+	}
+}
+
+func TestProfileApplyWrapsTheCommand(t *testing.T) {
+	profile, err := GetProfile(LanguageJava)
+	if err != nil {
+		t.Fatal(err)
+	}
+	container := &corev1.Container{Name: "app"}
+	if !profile.apply(container, config.InjectionModeCommand, []string{"java", "-jar", "app.jar"}, "/opt/zerok") {
+		t.Fatal("expected the container to be started through the agent script")
+	}
+
+	expectedCommand := []string{"/opt/zerok/zerok-agent.sh", "--"}
+	expectedArgs := []string{
+		"java",
+		"-javaagent:/opt/zerok/opentelemetry-javaagent.jar",
+		"-Dotel.javaagent.extensions=/opt/zerok/zk-otel-extension.jar",
+		"-jar", "app.jar",
+	}
+	if !reflect.DeepEqual(container.Command, expectedCommand) || !reflect.DeepEqual(container.Args, expectedArgs) {
+		t.Fatalf("got command %q and args %q", container.Command, container.Args)
+	}
+}
+
+func TestProfileApplyFallsBackToTheEnvironment(t *testing.T) {
+	profile, err := GetProfile(LanguageJava)
+	if err != nil {
+		t.Fatal(err)
+	}
+	container := &corev1.Container{
+		Name: "app",
+		Env:  []corev1.EnvVar{{Name: javaToolOptionsEnv, Value: "-Xmx1g"}},
+	}
+	if profile.apply(container, config.InjectionModeCommand, []string{"/app/start"}, "/opt/zerok") {
+		t.Fatal("expected no agent script")
+	}
+
+	if len(container.Command) != 0 {
+		t.Fatalf("expected the command to be left alone, got %q", container.Command)
+	}
+	expected := "-javaagent:/opt/zerok/opentelemetry-javaagent.jar -Dotel.javaagent.extensions=/opt/zerok/zk-otel-extension.jar -Xmx1g"
+	if value := container.Env[0].Value; value != expected {
+		t.Fatalf("expected %v=%q, got %q", javaToolOptionsEnv, expected, value)
+	}
+}
+
+func TestProfileWithoutTheAgentScriptUsesTheEnvironment(t *testing.T) {
+	profile := &Profile{
+		Language: LanguageJava,
+		Files:    []string{"opentelemetry-javaagent.jar"},
+		Argv:     &argvRule{binaries: []string{"java"}, options: []string{"-javaagent:" + agentPathPlaceholder + "/opentelemetry-javaagent.jar"}},
+		ArgvEnv:  []envRule{{name: javaToolOptionsEnv, value: "-javaagent:" + agentPathPlaceholder + "/opentelemetry-javaagent.jar", separator: " "}},
+	}
+	if profile.needsCommand(config.InjectionModeCommand) {
+		t.Fatal("expected no command without the agent script")
+	}
+	container := &corev1.Container{Name: "app"}
+	if profile.apply(container, config.InjectionModeCommand, nil, "/opt/zerok") || len(container.Command) != 0 {
+		t.Fatalf("expected the command to be left alone, got %q", container.Command)
+	}
+	if index := findEnv(container, javaToolOptionsEnv); index < 0 || container.Env[index].Value != "-javaagent:/opt/zerok/opentelemetry-javaagent.jar" {
+		t.Fatalf("expected the agent in %v, got %+v", javaToolOptionsEnv, container.Env)
+	}
+}
+
+func TestIsLanguageAvailable(t *testing.T) {
+	injectorConfig := config.Default()
+	for language, expected := range map[Language]bool{
+		LanguageJava:   true,
+		LanguageNodeJS: false,
+		LanguagePython: false,
+		LanguageDotNet: false,
+	} {
+		available, err := isLanguageAvailable(language, injectorConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if available != expected {
+			t.Errorf("expected %v to be available: %v", language, expected)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package inject
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	otelServiceNameEnv        = "OTEL_SERVICE_NAME"
+	otelResourceAttributesEnv = "OTEL_RESOURCE_ATTRIBUTES"
+	podNameEnv                = "ZK_POD_NAME"
+	podUIDEnv                 = "ZK_POD_UID"
+	podNamespaceEnv           = "ZK_POD_NAMESPACE"
+	nodeNameEnv               = "ZK_NODE_NAME"
+)
+
+var workloadAttributes = map[string]string{
+	"Deployment":  "k8s.deployment.name",
+	"ReplicaSet":  "k8s.replicaset.name",
+	"StatefulSet": "k8s.statefulset.name",
+	"DaemonSet":   "k8s.daemonset.name",
+	"Job":         "k8s.job.name",
+	"CronJob":     "k8s.cronjob.name",
+	// Argo Rollouts replace Deployments, so they are reported the same way.
+	"Rollout": "k8s.deployment.name",
+}
+
+func getServiceName(pod *corev1.Pod, container *corev1.Container, workload *Workload) string {
+	if name := pod.Labels["app.kubernetes.io/name"]; name != "" {
+		return name
+	}
+	if workload != nil && workload.Name != "" {
+		return workload.Name
+	}
+	return container.Name
+}
+
+// addResourceAttributes names the service and describes where it runs through the standard OpenTelemetry
+// variables. Values the user already set are never overwritten.
+func addResourceAttributes(pod *corev1.Pod, container *corev1.Container, workload *Workload) {
+	prependEnv(container, []corev1.EnvVar{
+		getFieldRefEnv(nodeNameEnv, "spec.nodeName"),
+		getFieldRefEnv(podNameEnv, "metadata.name"),
+		getFieldRefEnv(podNamespaceEnv, "metadata.namespace"),
+		getFieldRefEnv(podUIDEnv, "metadata.uid"),
+	})
+
+	attributes := [][2]string{
+		{"k8s.namespace.name", "$(" + podNamespaceEnv + ")"},
+		{"k8s.pod.name", "$(" + podNameEnv + ")"},
+		{"k8s.pod.uid", "$(" + podUIDEnv + ")"},
+		{"k8s.node.name", "$(" + nodeNameEnv + ")"},
+		{"k8s.container.name", container.Name},
+	}
+	if version := pod.Labels["app.kubernetes.io/version"]; version != "" {
+		attributes = append(attributes, [2]string{"service.version", version})
+	}
+	if workload != nil {
+		if attribute, ok := workloadAttributes[workload.Kind]; ok {
+			attributes = append(attributes, [2]string{attribute, workload.Name})
+		}
+	}
+
+	index := findEnv(container, otelResourceAttributesEnv)
+	existing := ""
+	existingKeys := map[string]bool{}
+	if index >= 0 && container.Env[index].ValueFrom == nil {
+		existing = strings.TrimSpace(container.Env[index].Value)
+		for _, pair := range strings.Split(existing, ",") {
+			existingKeys[strings.TrimSpace(strings.SplitN(pair, "=", 2)[0])] = true
+		}
+	}
+
+	// OTEL_SERVICE_NAME wins over the service.name of the resource attributes, so it is only set when the
+	// user named the service in neither.
+	if findEnv(container, otelServiceNameEnv) < 0 && !existingKeys["service.name"] {
+		container.Env = append(container.Env, corev1.EnvVar{Name: otelServiceNameEnv, Value: getServiceName(pod, container, workload)})
+	}
+
+	if index >= 0 && container.Env[index].ValueFrom != nil {
+		return
+	}
+
+	pairs := []string{}
+	for _, attribute := range attributes {
+		if !existingKeys[attribute[0]] {
+			pairs = append(pairs, attribute[0]+"="+attribute[1])
+		}
+	}
+	if len(pairs) == 0 {
+		return
+	}
+	if existing != "" {
+		pairs = append(pairs, existing)
+	}
+
+	value := strings.Join(pairs, ",")
+	if index >= 0 {
+		container.Env[index].Value = value
+		return
+	}
+	container.Env = append(container.Env, corev1.EnvVar{Name: otelResourceAttributesEnv, Value: value})
+}
+
+func getFieldRefEnv(name string, fieldPath string) corev1.EnvVar {
+	return corev1.EnvVar{
+		Name:      name,
+		ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: fieldPath}},
+	}
+}
@@ -0,0 +1,27 @@
+package inject
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestAddResourceAttributesKeepsTheServiceName(t *testing.T) {
+	pod := &corev1.Pod{}
+	container := &corev1.Container{Name: "app", Env: []corev1.EnvVar{{Name: otelResourceAttributesEnv, Value: "service.name=orders, team=payments"}}}
+	addResourceAttributes(pod, container, &Workload{Kind: "Deployment", Name: "orders-api"})
+	if index := findEnv(container, otelServiceNameEnv); index >= 0 {
+		t.Fatalf("expected no %v next to a service.name attribute, got %v", otelServiceNameEnv, container.Env[index].Value)
+	}
+	index := findEnv(container, otelResourceAttributesEnv)
+	expected := "k8s.namespace.name=$(ZK_POD_NAMESPACE),k8s.pod.name=$(ZK_POD_NAME),k8s.pod.uid=$(ZK_POD_UID),k8s.node.name=$(ZK_NODE_NAME),k8s.container.name=app,k8s.deployment.name=orders-api,service.name=orders, team=payments"
+	if container.Env[index].Value != expected {
+		t.Fatalf("expected %v, got %v", expected, container.Env[index].Value)
+	}
+
+	container = &corev1.Container{Name: "app"}
+	addResourceAttributes(pod, container, &Workload{Kind: "Deployment", Name: "orders-api"})
+	if index := findEnv(container, otelServiceNameEnv); index < 0 || container.Env[index].Value != "orders-api" {
+		t.Fatalf("expected the service to be named after the workload, got %+v", container.Env)
+	}
+}
@@ -0,0 +1,98 @@
+package inject
+
+import (
+	"path"
+	"regexp"
+	"strings"
+
+	dockercontainer "github.com/docker/docker/api/types/container"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	runtimesAnnotation = "zerok.ai/runtimes"
+	unknownRuntime     = "unknown"
+)
+
+var runtimeBinaries = map[Language]*regexp.Regexp{
+	LanguageJava:   regexp.MustCompile(`^java$`),
+	LanguageNodeJS: regexp.MustCompile(`^(node|nodejs|npm|npx|yarn)$`),
+	LanguagePython: regexp.MustCompile(`^(python[0-9.]*|gunicorn|uvicorn|celery|flask)$`),
+	LanguageDotNet: regexp.MustCompile(`^dotnet$`),
+}
+
+var runtimeEnv = map[string]Language{
+	"JAVA_HOME":                   LanguageJava,
+	"JAVA_VERSION":                LanguageJava,
+	"JDK_VERSION":                 LanguageJava,
+	"NODE_VERSION":                LanguageNodeJS,
+	"PYTHON_VERSION":              LanguagePython,
+	"DOTNET_VERSION":              LanguageDotNet,
+	"ASPNET_VERSION":              LanguageDotNet,
+	"DOTNET_RUNNING_IN_CONTAINER": LanguageDotNet,
+}
+
+var runtimeLabelKeywords = map[Language][]string{
+	LanguageJava:   {"openjdk", "temurin", "jdk", "jre", "corretto", "java"},
+	LanguageNodeJS: {"node"},
+	LanguagePython: {"python"},
+	LanguageDotNet: {"dotnet", "aspnet"},
+}
+
+var runtimeLabels = []string{
+	"org.opencontainers.image.base.name",
+	"org.opencontainers.image.title",
+	"org.opencontainers.image.description",
+}
+
+// detectRuntime classifies the runtime of a container, looking at the binaries it starts first, then at the
+// environment of the image and finally at its labels.
+func detectRuntime(imageConfig *dockercontainer.Config, argv []string) (Language, bool) {
+	for _, word := range argv {
+		for _, field := range strings.Fields(word) {
+			binary := path.Base(field)
+			for language, pattern := range runtimeBinaries {
+				if pattern.MatchString(binary) {
+					return language, true
+				}
+			}
+		}
+	}
+
+	if imageConfig == nil {
+		return "", false
+	}
+
+	for _, env := range imageConfig.Env {
+		name := strings.SplitN(env, "=", 2)[0]
+		if language, ok := runtimeEnv[name]; ok {
+			return language, true
+		}
+	}
+
+	for _, label := range runtimeLabels {
+		value := strings.ToLower(imageConfig.Labels[label])
+		if value == "" {
+			continue
+		}
+		for _, language := range []Language{LanguageJava, LanguageNodeJS, LanguagePython, LanguageDotNet} {
+			for _, keyword := range runtimeLabelKeywords[language] {
+				if strings.Contains(value, keyword) {
+					return language, true
+				}
+			}
+		}
+	}
+
+	return "", false
+}
+
+// detectSpecRuntime classifies the runtime of a container from its command, args and environment alone, for
+// the injection modes which never pull the image.
+func detectSpecRuntime(container *corev1.Container) (Language, bool) {
+	specConfig := &dockercontainer.Config{}
+	for _, env := range container.Env {
+		specConfig.Env = append(specConfig.Env, env.Name+"="+env.Value)
+	}
+	return detectRuntime(specConfig, resolveArgv(container, nil, nil))
+}
@@ -0,0 +1,62 @@
+package inject
+
+import (
+	"testing"
+
+	dockercontainer "github.com/docker/docker/api/types/container"
+	"github.com/zerok-ai/zerok-injector/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDetectRuntime(t *testing.T) {
+	for _, test := range []struct {
+		imageConfig *dockercontainer.Config
+		argv        []string
+		expected    Language
+		detected    bool
+	}{
+		{nil, []string{"java", "-jar", "app.jar"}, LanguageJava, true},
+		{nil, []string{"sh", "-c", "exec /usr/local/bin/node server.js"}, LanguageNodeJS, true},
+		{nil, []string{"gunicorn", "app:app"}, LanguagePython, true},
+		{&dockercontainer.Config{Env: []string{"JAVA_HOME=/opt/java"}}, []string{"/app/start"}, LanguageJava, true},
+		{&dockercontainer.Config{Labels: map[string]string{"org.opencontainers.image.base.name": "mcr.microsoft.com/dotnet/aspnet:8.0"}}, []string{"/app/start"}, LanguageDotNet, true},
+		{&dockercontainer.Config{}, []string{"/app/start"}, "", false},
+	} {
+		language, detected := detectRuntime(test.imageConfig, test.argv)
+		if language != test.expected || detected != test.detected {
+			t.Errorf("detectRuntime(%q) = %v, %v, expected %v, %v", test.argv, language, detected, test.expected, test.detected)
+		}
+	}
+}
+
+func TestDetectSpecRuntime(t *testing.T) {
+	language, detected := detectSpecRuntime(&corev1.Container{Env: []corev1.EnvVar{{Name: "NODE_VERSION", Value: "20"}}})
+	if !detected || language != LanguageNodeJS {
+		t.Fatalf("expected nodejs from the environment, got %v, %v", language, detected)
+	}
+	if _, detected := detectSpecRuntime(&corev1.Container{Image: "app"}); detected {
+		t.Fatal("expected no runtime for a container without command and environment")
+	}
+}
+
+func TestGetLanguageFromTheSpec(t *testing.T) {
+	injectorConfig := &config.InjectorConfig{InjectionMode: config.InjectionModeJavaToolOptions, DetectRuntime: true, DefaultLanguage: "java"}
+	getImageConfig := func() (*dockercontainer.Config, error) {
+		t.Fatal("expected no image lookup in the java-tool-options mode")
+		return nil, nil
+	}
+
+	language, ok, err := getLanguage(&corev1.Pod{}, &corev1.Container{Name: "app", Image: "python:3.12", Command: []string{"python", "app.py"}}, injectorConfig, getImageConfig)
+	if err != nil || !ok || language != LanguagePython {
+		t.Fatalf("expected python from the command, got %v, %v, %v", language, ok, err)
+	}
+	if _, ok, _ := getLanguage(&corev1.Pod{}, &corev1.Container{Name: "app", Image: "nginx"}, injectorConfig, getImageConfig); ok {
+		t.Fatal("expected a container the spec tells nothing about to be left alone")
+	}
+
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{languageAnnotation: "java"}}}
+	if language, ok, _ := getLanguage(pod, &corev1.Container{Name: "app", Image: "nginx"}, injectorConfig, getImageConfig); !ok || language != LanguageJava {
+		t.Fatalf("expected the annotated language, got %v, %v", language, ok)
+	}
+}
@@ -0,0 +1,111 @@
+package inject
+
+import (
+	"fmt"
+
+	"github.com/zerok-ai/zerok-injector/pkg/zkclient"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	podSecurityEnforceLabel = "pod-security.kubernetes.io/enforce"
+	podSecurityRestricted   = "restricted"
+	podSecurityBaseline     = "baseline"
+	// Used for the init container in restricted namespaces when neither the pod nor its containers set a user.
+	defaultNonRootUser = int64(65532)
+)
+
+func getPodSecurityLevel(namespace string) string {
+	labels, err := zkclient.GetNamespaceLabels(namespace)
+	if err != nil {
+		fmt.Printf("Error caught while reading the pod security level of namespace %v, assuming restricted: %v.\n", namespace, err)
+		return podSecurityRestricted
+	}
+	return labels[podSecurityEnforceLabel]
+}
+
+// getAppUser returns the user and group the instrumented containers run as, so that the agent files are
+// written by the same user which reads them.
+func getAppUser(pod *corev1.Pod, containerNames map[string]bool) (*int64, *int64) {
+	var runAsUser, runAsGroup *int64
+	if pod.Spec.SecurityContext != nil {
+		runAsUser = pod.Spec.SecurityContext.RunAsUser
+		runAsGroup = pod.Spec.SecurityContext.RunAsGroup
+	}
+	for _, container := range pod.Spec.Containers {
+		if !containerNames[container.Name] || container.SecurityContext == nil {
+			continue
+		}
+		if container.SecurityContext.RunAsUser != nil {
+			runAsUser = container.SecurityContext.RunAsUser
+		}
+		if container.SecurityContext.RunAsGroup != nil {
+			runAsGroup = container.SecurityContext.RunAsGroup
+		}
+		break
+	}
+	return runAsUser, runAsGroup
+}
+
+// getAgentFilesGroup returns the group owning the copied agent files: the group the application runs as, or
+// else the fsGroup of the pod, which the application always has as a supplementary group.
+func getAgentFilesGroup(pod *corev1.Pod, runAsGroup *int64) *int64 {
+	if runAsGroup == nil && pod.Spec.SecurityContext != nil {
+		return pod.Spec.SecurityContext.FSGroup
+	}
+	return runAsGroup
+}
+
+// getInitContainerSecurityContext completes the configured security context of the init container so that
+// it passes the pod security level enforced on the namespace. Fields set in the configuration are kept.
+func getInitContainerSecurityContext(pod *corev1.Pod, configured *corev1.SecurityContext, level string, containerNames map[string]bool) *corev1.SecurityContext {
+	securityContext := configured.DeepCopy()
+	if securityContext == nil {
+		securityContext = &corev1.SecurityContext{}
+	}
+
+	runAsUser, runAsGroup := getAppUser(pod, containerNames)
+	if securityContext.RunAsUser == nil {
+		securityContext.RunAsUser = runAsUser
+	}
+	if securityContext.RunAsGroup == nil {
+		securityContext.RunAsGroup = runAsGroup
+	}
+
+	if level == podSecurityBaseline || level == podSecurityRestricted {
+		if securityContext.Privileged == nil {
+			securityContext.Privileged = boolPtr(false)
+		}
+	}
+
+	if level == podSecurityRestricted {
+		if securityContext.AllowPrivilegeEscalation == nil {
+			securityContext.AllowPrivilegeEscalation = boolPtr(false)
+		}
+		if securityContext.Capabilities == nil {
+			securityContext.Capabilities = &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}}
+		}
+		if securityContext.RunAsNonRoot == nil {
+			securityContext.RunAsNonRoot = boolPtr(true)
+		}
+		if securityContext.RunAsUser == nil || *securityContext.RunAsUser == 0 {
+			nonRootUser := defaultNonRootUser
+			securityContext.RunAsUser = &nonRootUser
+		}
+		if securityContext.ReadOnlyRootFilesystem == nil {
+			securityContext.ReadOnlyRootFilesystem = boolPtr(true)
+		}
+		if securityContext.SeccompProfile == nil && (pod.Spec.SecurityContext == nil || pod.Spec.SecurityContext.SeccompProfile == nil) {
+			securityContext.SeccompProfile = &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault}
+		}
+	}
+
+	if *securityContext == (corev1.SecurityContext{}) {
+		return nil
+	}
+	return securityContext
+}
+
+func boolPtr(value bool) *bool {
+	return &value
+}
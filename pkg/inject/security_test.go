@@ -0,0 +1,38 @@
+package inject
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func int64Ptr(value int64) *int64 {
+	return &value
+}
+
+func TestGetAgentFilesGroupFallsBackToFSGroup(t *testing.T) {
+	pod := &corev1.Pod{Spec: corev1.PodSpec{SecurityContext: &corev1.PodSecurityContext{FSGroup: int64Ptr(2000)}}}
+	if group := getAgentFilesGroup(pod, nil); group == nil || *group != 2000 {
+		t.Fatalf("expected the fsGroup 2000, got %v", group)
+	}
+	if group := getAgentFilesGroup(pod, int64Ptr(3000)); *group != 3000 {
+		t.Fatalf("expected the runAsGroup 3000, got %v", *group)
+	}
+	if group := getAgentFilesGroup(&corev1.Pod{}, nil); group != nil {
+		t.Fatalf("expected no group, got %v", *group)
+	}
+}
+
+func TestGetInitContainerSecurityContextRestricted(t *testing.T) {
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}}}
+	securityContext := getInitContainerSecurityContext(pod, nil, podSecurityRestricted, map[string]bool{"app": true})
+	if securityContext == nil || securityContext.RunAsUser == nil || *securityContext.RunAsUser != defaultNonRootUser {
+		t.Fatalf("expected the default non root user, got %+v", securityContext)
+	}
+	if !*securityContext.RunAsNonRoot || *securityContext.AllowPrivilegeEscalation || securityContext.SeccompProfile == nil {
+		t.Fatalf("expected a restricted security context, got %+v", securityContext)
+	}
+	if securityContext := getInitContainerSecurityContext(pod, nil, "", map[string]bool{"app": true}); securityContext != nil {
+		t.Fatalf("expected no security context without a level, got %+v", securityContext)
+	}
+}
@@ -0,0 +1,68 @@
+package inject
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	injectAnnotation           = "zerok.ai/inject"
+	injectContainersAnnotation = "zerok.ai/inject-containers"
+	skipContainersAnnotation   = "zerok.ai/skip-containers"
+)
+
+// isInjectionEnabled honours the zerok.ai/inject annotation, falling back to the label of the same name, which
+// is what the webhook object selector matches on for pods from namespaces without the zk-injection label. The
+// annotation alone never opts such a pod in, since the webhook is not called for it.
+func isInjectionEnabled(pod *corev1.Pod) bool {
+	value, ok := pod.Annotations[injectAnnotation]
+	if !ok {
+		value = pod.Labels[injectAnnotation]
+	}
+	return strings.TrimSpace(value) != "false"
+}
+
+// shouldInjectContainer honours the container annotations. Containers added by service meshes and other
+// injectors are left alone unless zerok.ai/inject-containers names them explicitly.
+func shouldInjectContainer(pod *corev1.Pod, containerName string, meshContainers map[string]bool) bool {
+	if value, ok := pod.Annotations[injectContainersAnnotation]; ok {
+		if !parseContainerList(value)[containerName] {
+			return false
+		}
+	} else if meshContainers[containerName] {
+		return false
+	}
+	if value, ok := pod.Annotations[skipContainersAnnotation]; ok {
+		if parseContainerList(value)[containerName] {
+			return false
+		}
+	}
+	return true
+}
+
+func getContainersToInject(pod *corev1.Pod, meshContainers []string) []int {
+	meshContainerNames := map[string]bool{}
+	for _, name := range meshContainers {
+		meshContainerNames[name] = true
+	}
+
+	indexes := []int{}
+	for i := range pod.Spec.Containers {
+		if shouldInjectContainer(pod, pod.Spec.Containers[i].Name, meshContainerNames) {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+func parseContainerList(value string) map[string]bool {
+	names := map[string]bool{}
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			names[name] = true
+		}
+	}
+	return names
+}
@@ -0,0 +1,92 @@
+package inject
+
+import (
+	"encoding/json"
+	"fmt"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// verifyPatch applies the patch to the pod exactly as received from the api server and validates the result,
+// so that a broken patch never reaches the api server and fails the pod creation.
+func verifyPatch(rawPod []byte, patches []patchOperation) error {
+	patchBytes, err := json.Marshal(patches)
+	if err != nil {
+		return fmt.Errorf("error caught while marshalling the patches %v", err)
+	}
+
+	patch, err := jsonpatch.DecodePatch(patchBytes)
+	if err != nil {
+		return fmt.Errorf("error caught while decoding the patches %v", err)
+	}
+
+	patchedPodBytes, err := patch.Apply(rawPod)
+	if err != nil {
+		return fmt.Errorf("error caught while applying the patches %v", err)
+	}
+
+	patchedPod := corev1.Pod{}
+	if err := json.Unmarshal(patchedPodBytes, &patchedPod); err != nil {
+		return fmt.Errorf("error caught while unmarshalling the patched pod %v", err)
+	}
+
+	return validatePod(&patchedPod)
+}
+
+func validatePod(pod *corev1.Pod) error {
+	volumes := map[string]bool{}
+	for _, volume := range pod.Spec.Volumes {
+		if volume.Name == "" {
+			return fmt.Errorf("volume without a name")
+		}
+		if volumes[volume.Name] {
+			return fmt.Errorf("duplicate volume name %v", volume.Name)
+		}
+		volumes[volume.Name] = true
+	}
+
+	containerNames := map[string]bool{}
+	for _, container := range pod.Spec.InitContainers {
+		if err := validateContainer(&container, containerNames, volumes); err != nil {
+			return fmt.Errorf("invalid init container %v: %v", container.Name, err)
+		}
+	}
+	for _, container := range pod.Spec.Containers {
+		if err := validateContainer(&container, containerNames, volumes); err != nil {
+			return fmt.Errorf("invalid container %v: %v", container.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func validateContainer(container *corev1.Container, containerNames map[string]bool, volumes map[string]bool) error {
+	if container.Name == "" {
+		return fmt.Errorf("container without a name")
+	}
+	if containerNames[container.Name] {
+		return fmt.Errorf("duplicate container name")
+	}
+	containerNames[container.Name] = true
+
+	if container.Image == "" {
+		return fmt.Errorf("container without an image")
+	}
+
+	mountPaths := map[string]bool{}
+	for _, volumeMount := range container.VolumeMounts {
+		if !volumes[volumeMount.Name] {
+			return fmt.Errorf("volume mount %v does not refer to a volume", volumeMount.Name)
+		}
+		if volumeMount.MountPath == "" {
+			return fmt.Errorf("volume mount %v without a mount path", volumeMount.Name)
+		}
+		if mountPaths[volumeMount.MountPath] {
+			return fmt.Errorf("duplicate mount path %v", volumeMount.MountPath)
+		}
+		mountPaths[volumeMount.MountPath] = true
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package inject
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/zerok-ai/zerok-injector/pkg/zkclient"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	workloadKindAnnotation = "zerok.ai/workload-kind"
+	workloadNameAnnotation = "zerok.ai/workload-name"
+	maxOwnerChainLength    = 5
+)
+
+// Workload is the object owning the pod, like the Deployment behind its ReplicaSet.
+type Workload struct {
+	Kind string
+	Name string
+}
+
+// resolveWorkload walks the owner chain of the pod up to its workload: ReplicaSet to Deployment or Argo
+// Rollout, Job to CronJob. Pods of a StatefulSet or DaemonSet are owned by the workload directly.
+func resolveWorkload(pod *corev1.Pod) *Workload {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return nil
+	}
+
+	workload := &Workload{Kind: owner.Kind, Name: owner.Name}
+	for i := 0; i < maxOwnerChainLength; i++ {
+		if workload.Kind != "ReplicaSet" && workload.Kind != "Job" {
+			return workload
+		}
+		next, err := zkclient.GetControllerOfOwner(pod.Namespace, workload.Kind, workload.Name)
+		if err != nil {
+			fmt.Printf("Error caught while resolving the owner of %v %v, guessing it from the names: %v.\n", workload.Kind, workload.Name, err)
+			return guessWorkload(pod, workload)
+		}
+		if next == nil {
+			return workload
+		}
+		workload = &Workload{Kind: next.Kind, Name: next.Name}
+	}
+	return workload
+}
+
+// guessWorkload derives the workload without looking up any other object: a ReplicaSet created by a
+// Deployment is named after it with the pod template hash as suffix.
+func guessWorkload(pod *corev1.Pod, owner *Workload) *Workload {
+	if hash, ok := pod.Labels["pod-template-hash"]; ok && owner.Kind == "ReplicaSet" && strings.HasSuffix(owner.Name, "-"+hash) {
+		return &Workload{Kind: "Deployment", Name: strings.TrimSuffix(owner.Name, "-"+hash)}
+	}
+	return owner
+}
+
+func recordWorkload(pod *corev1.Pod, workload *Workload) {
+	if workload == nil {
+		return
+	}
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations[workloadKindAnnotation] = workload.Kind
+	pod.Annotations[workloadNameAnnotation] = workload.Name
+}
@@ -9,37 +9,59 @@ import (
 	"time"
 
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/client"
 )
 
-func GetCommandFromImage(image string, authConfig *types.AuthConfig, uid string) ([]string, error) {
+func GetCommandFromImage(image string, authConfig *types.AuthConfig, namespace string, uid string) ([]string, error) {
+	imageConfig, err := GetImageConfig(image, authConfig, namespace, uid)
+	if err != nil {
+		return []string{}, err
+	}
+	return imageConfig.Cmd, nil
+}
+
+func GetImageConfig(image string, authConfig *types.AuthConfig, namespace string, uid string) (*container.Config, error) {
 
 	start := time.Now()
-	fmt.Println("Started pulling the docker image ", image, "with uid ", uid, " at time ", start.String())
 	ctx := context.TODO()
 	dockerClient, _ := client.NewClientWithOpts(client.FromEnv)
 
-	var reader io.ReadCloser
-	var imagePullOptions types.ImagePullOptions
+	authStr, err := encodeAuthConfig(authConfig)
+	if err != nil {
+		fmt.Println("Error while marshalling Auth details")
+		return nil, fmt.Errorf("error while marshalling Auth details for image %v, Error is: %v", image, err)
+	}
+
+	scope := getImageScope(namespace, authConfig)
 
-	if authConfig != nil {
-		encodedJSON, err := json.Marshal(&authConfig)
-		if err != nil {
-			fmt.Println("Error while marshalling Auth details")
-			return []string{}, fmt.Errorf("error while marshalling Auth details for image %v, Error is: %v", image, err)
+	imageConfig, err := getScopedImageConfig(image, scope, func() error {
+		// The image is in the shared cache, but was fetched by someone else. Make sure this scope can access it
+		// on the registry before handing out the metadata.
+		fmt.Println("Verifying registry access for cached image ", image, "with uid ", uid)
+		if _, err := dockerClient.DistributionInspect(ctx, image, authStr); err != nil {
+			fmt.Println("Error while verifying registry access for image ", image, ", Error is: ", err)
+			return fmt.Errorf("error caught while verifying access to the image: %v, Error is: %v", image, err)
 		}
-		authStr := base64.URLEncoding.EncodeToString(encodedJSON)
-		imagePullOptions = types.ImagePullOptions{RegistryAuth: authStr}
-
-	} else {
-		imagePullOptions = types.ImagePullOptions{}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if imageConfig != nil {
+		fmt.Printf("getting command from cache took %v for request %v.\n", time.Since(start), uid)
+		return imageConfig, nil
 	}
 
+	fmt.Println("Started pulling the docker image ", image, "with uid ", uid, " at time ", start.String())
+
+	imagePullOptions := types.ImagePullOptions{RegistryAuth: authStr}
+
 	reader, err := dockerClient.ImagePull(ctx, image, imagePullOptions)
 
 	if err != nil {
 		fmt.Println("Error while pulling the docker image ", err)
-		return []string{}, fmt.Errorf("error caught while pulling the image: %v, Error is: %v", image, err)
+		return nil, fmt.Errorf("error caught while pulling the image: %v, Error is: %v", image, err)
 	}
 
 	io.ReadAll(reader)
@@ -47,7 +69,7 @@ func GetCommandFromImage(image string, authConfig *types.AuthConfig, uid string)
 	if reader != nil {
 		fmt.Println("Pulled the docker image ", image, "with uid ", uid)
 	} else {
-		return []string{}, fmt.Errorf("image is empty: %v", image)
+		return nil, fmt.Errorf("image is empty: %v", image)
 	}
 
 	defer reader.Close()
@@ -56,11 +78,28 @@ func GetCommandFromImage(image string, authConfig *types.AuthConfig, uid string)
 
 	if err != nil {
 		fmt.Println("Error caught while getting cmd from image: ", image, ", Error is: ", err)
-		return []string{}, fmt.Errorf("error caught while getting cmd from image: %v, Error is: %v", image, err)
+		return nil, fmt.Errorf("error caught while getting cmd from image: %v, Error is: %v", image, err)
 	}
 
+	if imageInspect.Config == nil {
+		return nil, fmt.Errorf("image config is empty: %v", image)
+	}
+
+	putCachedImageConfig(image, scope, imageInspect.Config)
+
 	elapsed := time.Since(start)
 	fmt.Printf("getting command took %v for request %v.\n", int64(elapsed/time.Second), uid)
 
-	return imageInspect.Config.Cmd, nil
+	return imageInspect.Config, nil
+}
+
+func encodeAuthConfig(authConfig *types.AuthConfig) (string, error) {
+	if authConfig == nil {
+		return "", nil
+	}
+	encodedJSON, err := json.Marshal(authConfig)
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(encodedJSON), nil
 }
@@ -0,0 +1,112 @@
+package zkclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	imageVolumeMinorVersion = 31
+	featureCheckTTL         = time.Hour
+)
+
+var (
+	imageVolumeSupported bool
+	imageVolumeCheckedAt time.Time
+	imageVolumeMutex     sync.Mutex
+)
+
+// IsImageVolumeSupported tells whether pods can mount images as volumes. The api server has to be 1.31 or
+// newer, and since the ImageVolume feature gate may still be off, a pod with an image volume is created with
+// dry run in the given namespace: with the gate off the volume source is dropped and the pod is rejected. The
+// answer is kept for an hour.
+func IsImageVolumeSupported(namespace string, image string) bool {
+	imageVolumeMutex.Lock()
+	defer imageVolumeMutex.Unlock()
+	if !imageVolumeCheckedAt.IsZero() && time.Since(imageVolumeCheckedAt) < featureCheckTTL {
+		return imageVolumeSupported
+	}
+
+	supported, err := probeImageVolume(namespace, image)
+	if err != nil {
+		fmt.Printf("Image volumes are not available, delivering the agent through the init container: %v.\n", err)
+	}
+	imageVolumeSupported = supported
+	imageVolumeCheckedAt = time.Now()
+	return supported
+}
+
+func probeImageVolume(namespace string, image string) (bool, error) {
+	clientSet := GetK8sClient()
+
+	version, err := clientSet.Discovery().ServerVersion()
+	if err != nil {
+		return false, fmt.Errorf("error caught while getting the server version: %v", err)
+	}
+	major, _ := strconv.Atoi(strings.TrimRight(version.Major, "+"))
+	minor, _ := strconv.Atoi(strings.TrimRight(version.Minor, "+"))
+	if major < 1 || (major == 1 && minor < imageVolumeMinorVersion) {
+		return false, fmt.Errorf("server version %v.%v is older than 1.%v", version.Major, version.Minor, imageVolumeMinorVersion)
+	}
+
+	// The pod is built as json, since the image volume source is newer than the client types.
+	probe := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata": map[string]interface{}{
+			"generateName": "zk-image-volume-probe-",
+			"labels":       map[string]interface{}{"zerok.ai/inject": "false"},
+		},
+		"spec": map[string]interface{}{
+			"containers": []interface{}{
+				map[string]interface{}{
+					"name":         "probe",
+					"image":        image,
+					"volumeMounts": []interface{}{map[string]interface{}{"name": "probe", "mountPath": "/probe"}},
+				},
+			},
+			"volumes": []interface{}{
+				map[string]interface{}{"name": "probe", "image": map[string]interface{}{"reference": image}},
+			},
+		},
+	}
+	body, err := json.Marshal(probe)
+	if err != nil {
+		return false, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancel()
+	result := clientSet.CoreV1().RESTClient().Post().
+		Namespace(namespace).
+		Resource("pods").
+		Param("dryRun", "All").
+		Body(body).
+		Do(ctx)
+	if err := result.Error(); err != nil {
+		return false, fmt.Errorf("dry run of a pod with an image volume failed: %v", err)
+	}
+
+	created := map[string]interface{}{}
+	raw, err := result.Raw()
+	if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(raw, &created); err != nil {
+		return false, err
+	}
+	// Some versions drop the disabled field silently instead of rejecting the pod.
+	spec, _ := created["spec"].(map[string]interface{})
+	volumes, _ := spec["volumes"].([]interface{})
+	for _, volume := range volumes {
+		if volumeMap, ok := volume.(map[string]interface{}); ok && volumeMap["image"] != nil {
+			return true, nil
+		}
+	}
+	return false, fmt.Errorf("the api server dropped the image volume source")
+}
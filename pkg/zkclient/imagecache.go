@@ -0,0 +1,91 @@
+package zkclient
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+// Image metadata is cached per image reference. Each entry remembers the scopes (credential or namespace)
+// that have been verified against the registry, since all pulls land in the same shared docker daemon.
+type imageCacheEntry struct {
+	config   *container.Config
+	scopes   map[string]bool
+	cachedAt time.Time
+}
+
+var (
+	imageCacheTTL   = 10 * time.Minute
+	imageCache      = map[string]*imageCacheEntry{}
+	imageCacheMutex sync.Mutex
+)
+
+func getImageScope(namespace string, authConfig *types.AuthConfig) string {
+	if authConfig == nil {
+		return "namespace:" + namespace
+	}
+	hash := sha256.Sum256([]byte(authConfig.Username + "\x00" + authConfig.Password + "\x00" + authConfig.Auth + "\x00" + authConfig.IdentityToken + "\x00" + authConfig.RegistryToken))
+	return "credential:" + hex.EncodeToString(hash[:])
+}
+
+func getCachedImageConfig(image string, scope string) (*container.Config, bool) {
+	imageCacheMutex.Lock()
+	defer imageCacheMutex.Unlock()
+
+	entry, ok := imageCache[image]
+	if !ok {
+		return nil, false
+	}
+	if time.Since(entry.cachedAt) > imageCacheTTL {
+		delete(imageCache, image)
+		return nil, false
+	}
+	return entry.config, entry.scopes[scope]
+}
+
+// getScopedImageConfig returns the cached metadata of the image for the scope, nil when the image is not
+// cached. A scope which has not been verified yet only gets the metadata once verify confirmed it can access
+// the image on the registry.
+func getScopedImageConfig(image string, scope string, verify func() error) (*container.Config, error) {
+	imageConfig, verified := getCachedImageConfig(image, scope)
+	if imageConfig == nil || verified {
+		return imageConfig, nil
+	}
+	if err := verify(); err != nil {
+		return nil, err
+	}
+	addImageScope(image, scope)
+	return imageConfig, nil
+}
+
+func putCachedImageConfig(image string, scope string, config *container.Config) {
+	imageCacheMutex.Lock()
+	defer imageCacheMutex.Unlock()
+
+	// Scopes verified for an entry which has not expired keep their access, pulling the image again under
+	// another pull secret does not take it away from them.
+	scopes := map[string]bool{scope: true}
+	if entry, ok := imageCache[image]; ok && time.Since(entry.cachedAt) <= imageCacheTTL {
+		for existing := range entry.scopes {
+			scopes[existing] = true
+		}
+	}
+	imageCache[image] = &imageCacheEntry{
+		config:   config,
+		scopes:   scopes,
+		cachedAt: time.Now(),
+	}
+}
+
+func addImageScope(image string, scope string) {
+	imageCacheMutex.Lock()
+	defer imageCacheMutex.Unlock()
+
+	if entry, ok := imageCache[image]; ok {
+		entry.scopes[scope] = true
+	}
+}
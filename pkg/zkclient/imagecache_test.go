@@ -0,0 +1,56 @@
+package zkclient
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+func resetImageCache() {
+	imageCacheMutex.Lock()
+	imageCache = map[string]*imageCacheEntry{}
+	imageCacheMutex.Unlock()
+}
+
+func TestUnverifiedScopeNeedsTheRegistryCheck(t *testing.T) {
+	resetImageCache()
+	tenantA := getImageScope("tenant-a", &types.AuthConfig{Username: "a", Password: "secret-a"})
+	tenantB := getImageScope("tenant-b", &types.AuthConfig{Username: "b", Password: "secret-b"})
+	putCachedImageConfig("registry.local/private:1", tenantA, &container.Config{Cmd: []string{"java"}})
+
+	denied := func() error { return fmt.Errorf("unauthorized") }
+	if imageConfig, err := getScopedImageConfig("registry.local/private:1", tenantB, denied); err == nil || imageConfig != nil {
+		t.Fatalf("expected another tenant to be refused the cached metadata, got %v, %v", imageConfig, err)
+	}
+
+	checks := 0
+	allowed := func() error { checks++; return nil }
+	for i := 0; i < 2; i++ {
+		if imageConfig, err := getScopedImageConfig("registry.local/private:1", tenantB, allowed); err != nil || imageConfig == nil {
+			t.Fatalf("expected the verified tenant to get the metadata, got %v, %v", imageConfig, err)
+		}
+	}
+	if checks != 1 {
+		t.Fatalf("expected a single registry check, got %v", checks)
+	}
+
+	if _, err := getScopedImageConfig("registry.local/private:1", tenantA, denied); err != nil {
+		t.Fatalf("expected the tenant which pulled the image not to be checked again: %v", err)
+	}
+}
+
+func TestPullUnderAnotherScopeKeepsVerifiedScopes(t *testing.T) {
+	resetImageCache()
+	namespaceScope := getImageScope("team", nil)
+	credentialScope := getImageScope("team", &types.AuthConfig{Username: "team", Password: "secret"})
+	putCachedImageConfig("registry.local/app:1", namespaceScope, &container.Config{})
+	putCachedImageConfig("registry.local/app:1", credentialScope, &container.Config{})
+
+	for _, scope := range []string{namespaceScope, credentialScope} {
+		if _, verified := getCachedImageConfig("registry.local/app:1", scope); !verified {
+			t.Fatalf("expected the scope %v to stay verified", scope)
+		}
+	}
+}
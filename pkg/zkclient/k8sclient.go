@@ -7,6 +7,8 @@ import (
 	"strings"
 
 	"github.com/docker/docker/api/types"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -77,6 +79,104 @@ func GetAuthDetailsFromSecret(names []string, namespace string, image string) (*
 	return authConfig, nil
 }
 
+// GetContainerLimitRangeMaximums returns the lowest maximum per resource the LimitRanges of the namespace
+// set for containers.
+func GetContainerLimitRangeMaximums(namespace string) (corev1.ResourceList, error) {
+	clientSet := GetK8sClient()
+	limitRanges, err := clientSet.CoreV1().LimitRanges(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		fmt.Println("Error caught while listing the limit ranges ", err)
+		return nil, fmt.Errorf("error caught while listing the limit ranges in namespace %v", namespace)
+	}
+
+	maximums := corev1.ResourceList{}
+	for _, limitRange := range limitRanges.Items {
+		for _, limit := range limitRange.Spec.Limits {
+			if limit.Type != corev1.LimitTypeContainer {
+				continue
+			}
+			for name, max := range limit.Max {
+				if existing, ok := maximums[name]; !ok || max.Cmp(existing) < 0 {
+					maximums[name] = max
+				}
+			}
+		}
+	}
+	return maximums, nil
+}
+
+// GetNamespaceLabels reads the labels of a namespace from the informer cache, falling back to the api server
+// while the informers are not started or when the namespace has not reached the cache yet.
+func GetNamespaceLabels(namespace string) (map[string]string, error) {
+	listersMutex.RLock()
+	listers := listers
+	listersMutex.RUnlock()
+	if listers != nil {
+		if ns, err := listers.namespaceLister.Get(namespace); err == nil {
+			return ns.Labels, nil
+		}
+	}
+
+	clientSet := GetK8sClient()
+	ns, err := clientSet.CoreV1().Namespaces().Get(context.TODO(), namespace, metav1.GetOptions{})
+	if err != nil {
+		fmt.Println("Error caught while getting the namespace ", err)
+		return nil, fmt.Errorf("error caught while getting the namespace %v", namespace)
+	}
+	return ns.Labels, nil
+}
+
+// GetEnvFromKeys returns the keys of the ConfigMap or Secret an envFrom source refers to. A missing source has
+// no keys.
+func GetEnvFromKeys(namespace string, source corev1.EnvFromSource) ([]string, error) {
+	clientSet := GetK8sClient()
+	keys := []string{}
+	if source.ConfigMapRef != nil {
+		configMap, err := clientSet.CoreV1().ConfigMaps(namespace).Get(context.TODO(), source.ConfigMapRef.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return keys, nil
+		}
+		if err != nil {
+			fmt.Println("Error caught while getting the config map ", err)
+			return nil, fmt.Errorf("error caught while getting the config map %v in namespace %v", source.ConfigMapRef.Name, namespace)
+		}
+		for key := range configMap.Data {
+			keys = append(keys, key)
+		}
+		for key := range configMap.BinaryData {
+			keys = append(keys, key)
+		}
+	} else if source.SecretRef != nil {
+		secret, err := clientSet.CoreV1().Secrets(namespace).Get(context.TODO(), source.SecretRef.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return keys, nil
+		}
+		if err != nil {
+			fmt.Println("Error caught while getting the secret ", err)
+			return nil, fmt.Errorf("error caught while getting the secret %v in namespace %v", source.SecretRef.Name, namespace)
+		}
+		for key := range secret.Data {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// HasSecretKey tells whether the secret exists in the namespace and holds the key.
+func HasSecretKey(namespace string, name string, key string) (bool, error) {
+	clientSet := GetK8sClient()
+	secret, err := clientSet.CoreV1().Secrets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		fmt.Println("Error caught while getting the secret ", err)
+		return false, fmt.Errorf("error caught while getting the secret %v in namespace %v", name, namespace)
+	}
+	_, ok := secret.Data[key]
+	return ok, nil
+}
+
 func GetK8sClient() *kubernetes.Clientset {
 	config, err := rest.InClusterConfig()
 	if err != nil {
@@ -0,0 +1,101 @@
+package zkclient
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	batchlisters "k8s.io/client-go/listers/batch/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+type ownerListers struct {
+	clientSet        *kubernetes.Clientset
+	replicaSetLister appslisters.ReplicaSetLister
+	jobLister        batchlisters.JobLister
+	namespaceLister  corelisters.NamespaceLister
+}
+
+var (
+	listers      *ownerListers
+	listersMutex sync.RWMutex
+)
+
+// StartOwnerInformers starts the cached informers used to walk the owner chain of pods. Only ReplicaSets and
+// Jobs are watched, since those are the intermediate owners between a pod and its workload. Namespaces are
+// watched as well, so that their labels are read without a request on every admission.
+func StartOwnerInformers(stopCh <-chan struct{}) error {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return err
+	}
+	clientSet, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+
+	factory := informers.NewSharedInformerFactory(clientSet, 10*time.Minute)
+	replicaSetInformer := factory.Apps().V1().ReplicaSets()
+	jobInformer := factory.Batch().V1().Jobs()
+	namespaceInformer := factory.Core().V1().Namespaces()
+	replicaSetSynced := replicaSetInformer.Informer().HasSynced
+	jobSynced := jobInformer.Informer().HasSynced
+	namespaceSynced := namespaceInformer.Informer().HasSynced
+
+	factory.Start(stopCh)
+	if !cache.WaitForCacheSync(stopCh, replicaSetSynced, jobSynced, namespaceSynced) {
+		return fmt.Errorf("timed out waiting for the owner informers to sync")
+	}
+
+	listersMutex.Lock()
+	listers = &ownerListers{
+		clientSet:        clientSet,
+		replicaSetLister: replicaSetInformer.Lister(),
+		jobLister:        jobInformer.Lister(),
+		namespaceLister:  namespaceInformer.Lister(),
+	}
+	listersMutex.Unlock()
+	fmt.Println("Owner informers synced.")
+	return nil
+}
+
+// GetControllerOfOwner returns the controller of a ReplicaSet or Job. A nil reference means the object has no
+// controller, or is of a kind which is not walked. Objects created moments ago may not have reached the cache
+// yet, in which case they are read from the api server.
+func GetControllerOfOwner(namespace string, kind string, name string) (*metav1.OwnerReference, error) {
+	listersMutex.RLock()
+	listers := listers
+	listersMutex.RUnlock()
+	if listers == nil {
+		return nil, fmt.Errorf("owner informers are not started")
+	}
+
+	switch kind {
+	case "ReplicaSet":
+		replicaSet, err := listers.replicaSetLister.ReplicaSets(namespace).Get(name)
+		if err != nil {
+			replicaSet, err = listers.clientSet.AppsV1().ReplicaSets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+			if err != nil {
+				return nil, fmt.Errorf("error caught while getting the replicaset %v in namespace %v: %v", name, namespace, err)
+			}
+		}
+		return metav1.GetControllerOf(replicaSet), nil
+	case "Job":
+		job, err := listers.jobLister.Jobs(namespace).Get(name)
+		if err != nil {
+			job, err = listers.clientSet.BatchV1().Jobs(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+			if err != nil {
+				return nil, fmt.Errorf("error caught while getting the job %v in namespace %v: %v", name, namespace, err)
+			}
+		}
+		return metav1.GetControllerOf(job), nil
+	}
+	return nil, nil
+}